@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/robertlestak/txwatch/internal/etx"
+)
+
+// printJSON writes a transaction to stdout as indented JSON
+func printJSON(t *etx.Transaction) error {
+	jd, jerr := json.MarshalIndent(t, "", "  ")
+	if jerr != nil {
+		return jerr
+	}
+	fmt.Println(string(jd))
+	return nil
+}
+
+// cmdAdd watches a new transaction, printing the transaction as
+// it is stored in the database
+func cmdAdd(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	txid := fs.String("txid", "", "transaction hash")
+	chain := fs.String("chain", "", "blockchain name")
+	callback := fs.String("callback", "", "callback URL")
+	tenant := fs.String("tenant", "", "tenant ID")
+	fs.Parse(args)
+	t := &etx.Transaction{
+		ID:          *txid,
+		TenantID:    *tenant,
+		Blockchain:  *chain,
+		CallbackURL: *callback,
+	}
+	if verr := t.Validate(); verr != nil {
+		return verr
+	}
+	if terr := t.New(ctx); terr != nil && terr != etx.ErrExists {
+		return terr
+	}
+	return printJSON(t)
+}
+
+// cmdStatus prints a transaction by txid
+func cmdStatus(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	txid := fs.String("txid", "", "transaction hash")
+	tenant := fs.String("tenant", "", "tenant ID")
+	fs.Parse(args)
+	if *txid == "" {
+		return errors.New("txid required")
+	}
+	t := &etx.Transaction{}
+	res := etx.DB.WithContext(ctx).Scopes(etx.TenantScope(*tenant)).Find(t, "id = ?", *txid)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return etx.ErrNotFound
+	}
+	return printJSON(t)
+}
+
+// runCommand runs the CLI subcommand named by the first argument
+// against the configured database
+func runCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "add":
+		return cmdAdd(ctx, args[1:])
+	case "status":
+		return cmdStatus(ctx, args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "usage: %s [add|status] [flags]\n", os.Args[0])
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
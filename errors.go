@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/robertlestak/txwatch/internal/etx"
+)
+
+// ErrorCode is a stable identifier of an error response, which clients can
+// branch on rather than matching error messages
+type ErrorCode string
+
+const (
+	CodeBadRequest         ErrorCode = "bad_request"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeConflict           ErrorCode = "conflict"
+	CodePayloadTooLarge    ErrorCode = "payload_too_large"
+	CodeRateLimited        ErrorCode = "rate_limited"
+	CodeInternal           ErrorCode = "internal_error"
+	CodeBadGateway         ErrorCode = "bad_gateway"
+	CodeUnavailable        ErrorCode = "unavailable"
+	CodeTenantRequired     ErrorCode = "tenant_required"
+	CodeInvalidTxID        ErrorCode = "invalid_txid"
+	CodeInvalidBlockchain  ErrorCode = "invalid_blockchain"
+	CodeInvalidAddress     ErrorCode = "invalid_address"
+	CodeExists             ErrorCode = "transaction_exists"
+	CodeBlockchainConflict ErrorCode = "blockchain_conflict"
+	CodeAddressExists      ErrorCode = "address_exists"
+	CodeReverted           ErrorCode = "reverted"
+	CodeDatabase           ErrorCode = "database_error"
+)
+
+// statusCodes are the codes of errors which have no more specific code
+var statusCodes = map[int]ErrorCode{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusBadGateway:            CodeBadGateway,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// ErrorResponse is the JSON body of every error response
+type ErrorResponse struct {
+	Error string    `json:"error"`
+	Code  ErrorCode `json:"code"`
+}
+
+// writeErrorCode sends an error message and code as a JSON body with the
+// provided status code
+func writeErrorCode(w http.ResponseWriter, msg string, status int, code ErrorCode) {
+	jd, _ := json.Marshal(&ErrorResponse{Error: msg, Code: code})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprint(w, string(jd))
+}
+
+// jsonError sends an error message as a JSON body with the provided status
+// code, and the error code of the status
+func jsonError(w http.ResponseWriter, msg string, status int) {
+	writeErrorCode(w, msg, status, statusErrorCode(status))
+}
+
+// writeError sends an error as a JSON body with the provided status code,
+// and the error code of the error
+func writeError(w http.ResponseWriter, err error, status int) {
+	writeErrorCode(w, err.Error(), status, errorCode(err, status))
+}
+
+// statusErrorCode returns the error code of a status code
+func statusErrorCode(status int) ErrorCode {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status < http.StatusInternalServerError {
+		return CodeBadRequest
+	}
+	return CodeInternal
+}
+
+// errorCode returns the error code of an error returned by etx, or the
+// error code of the status code for other errors
+func errorCode(err error, status int) ErrorCode {
+	switch {
+	case errors.Is(err, etx.ErrInvalidTxID):
+		return CodeInvalidTxID
+	case errors.Is(err, etx.ErrInvalidBlockchain), errors.Is(err, etx.ErrClientNotFound):
+		return CodeInvalidBlockchain
+	case errors.Is(err, etx.ErrInvalidAddress):
+		return CodeInvalidAddress
+	case errors.Is(err, etx.ErrExists):
+		return CodeExists
+	case errors.Is(err, etx.ErrConflict):
+		return CodeBlockchainConflict
+	case errors.Is(err, etx.ErrAddressExists):
+		return CodeAddressExists
+	case errors.Is(err, etx.ErrReverted):
+		return CodeReverted
+	case errors.Is(err, etx.ErrBackend):
+		return CodeDatabase
+	default:
+		return statusErrorCode(status)
+	}
+}
+
+// errorStatus returns the HTTP status code for an error returned by etx, so that
+// clients can tell their own bad requests apart from retryable server errors
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, etx.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, etx.ErrExists), errors.Is(err, etx.ErrConflict),
+		errors.Is(err, etx.ErrTenantConflict), errors.Is(err, etx.ErrAddressExists),
+		errors.Is(err, etx.ErrReverted), errors.Is(err, etx.ErrNotFailed):
+		return http.StatusConflict
+	case errors.Is(err, etx.ErrInvalidTxID), errors.Is(err, etx.ErrInvalidBlockchain),
+		errors.Is(err, etx.ErrInvalidAddress), errors.Is(err, etx.ErrClientNotFound):
+		return http.StatusBadRequest
+	case errors.Is(err, etx.ErrNoHealthyClient):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/robertlestak/txwatch/internal/etx"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   ErrorCode
+	}{
+		{etx.ErrNotFound, http.StatusNotFound, CodeNotFound},
+		{etx.ErrExists, http.StatusConflict, CodeExists},
+		{etx.ErrConflict, http.StatusConflict, CodeBlockchainConflict},
+		{etx.ErrTenantConflict, http.StatusConflict, CodeConflict},
+		{etx.ErrAddressExists, http.StatusConflict, CodeAddressExists},
+		{etx.ErrReverted, http.StatusConflict, CodeReverted},
+		{etx.ErrNotFailed, http.StatusConflict, CodeConflict},
+		{etx.ErrInvalidTxID, http.StatusBadRequest, CodeInvalidTxID},
+		{fmt.Errorf("%w %q", etx.ErrInvalidBlockchain, "sol"), http.StatusBadRequest, CodeInvalidBlockchain},
+		{etx.ErrInvalidAddress, http.StatusBadRequest, CodeInvalidAddress},
+		{etx.ErrClientNotFound, http.StatusBadRequest, CodeInvalidBlockchain},
+		{etx.ErrNoHealthyClient, http.StatusServiceUnavailable, CodeUnavailable},
+		{fmt.Errorf("%w: connection reset", etx.ErrBackend), http.StatusInternalServerError, CodeDatabase},
+		{errors.New("unexpected"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, tt := range tests {
+		status := errorStatus(tt.err)
+		if status != tt.status {
+			t.Errorf("errorStatus(%v) = %d, want %d", tt.err, status, tt.status)
+		}
+		if code := errorCode(tt.err, status); code != tt.code {
+			t.Errorf("errorCode(%v) = %s, want %s", tt.err, code, tt.code)
+		}
+	}
+}
+
+func TestStatusErrorCode(t *testing.T) {
+	tests := []struct {
+		status int
+		code   ErrorCode
+	}{
+		{http.StatusBadRequest, CodeBadRequest},
+		{http.StatusForbidden, CodeForbidden},
+		{http.StatusRequestEntityTooLarge, CodePayloadTooLarge},
+		{http.StatusTooManyRequests, CodeRateLimited},
+		{http.StatusMethodNotAllowed, CodeBadRequest},
+		{http.StatusGatewayTimeout, CodeInternal},
+	}
+	for _, tt := range tests {
+		if code := statusErrorCode(tt.status); code != tt.code {
+			t.Errorf("statusErrorCode(%d) = %s, want %s", tt.status, code, tt.code)
+		}
+	}
+}
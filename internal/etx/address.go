@@ -0,0 +1,184 @@
+package etx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidAddress is returned when a watched address is not a hex address
+	ErrInvalidAddress = errors.New("invalid address, must be a 0x-prefixed 20 byte hex string")
+	// ErrAddressExists is returned when an address is already watched on a blockchain
+	ErrAddressExists = errors.New("address already watched")
+)
+
+// WatchedAddress is an account whose outgoing transactions are
+// automatically added to the monitor
+type WatchedAddress struct {
+	gorm.Model
+	Address    string `json:"address"`
+	Blockchain string `json:"blockchain"`
+	TenantID   string `json:"tenantId"`
+	// LastBlock is the last block which was scanned for transactions
+	LastBlock uint64 `json:"lastBlock"`
+}
+
+// AddressScanBlocks returns the maximum number of blocks scanned for transactions
+// from watched addresses on each run, configured with ADDRESS_SCAN_BLOCKS. Zero
+// disables address watching
+func AddressScanBlocks() uint64 {
+	sb, serr := strconv.ParseUint(os.Getenv("ADDRESS_SCAN_BLOCKS"), 10, 64)
+	if serr != nil {
+		return 0
+	}
+	return sb
+}
+
+// New registers an address to be watched. Scanning starts after startBlock,
+// or after the current head of the blockchain if startBlock is zero
+func (a *WatchedAddress) New(ctx context.Context, startBlock uint64) error {
+	log.WithFields(log.Fields{
+		"action":  "address.New",
+		"address": a.Address,
+	}).Printf("blockchain=%s startBlock=%d", a.Blockchain, startBlock)
+	if !common.IsHexAddress(a.Address) {
+		return ErrInvalidAddress
+	}
+	a.Address = common.HexToAddress(a.Address).Hex()
+	a.Blockchain = ResolveBlockchain(a.Blockchain)
+	res := DB.WithContext(ctx).Scopes(TenantScope(a.TenantID)).
+		Find(&WatchedAddress{}, "address = ? AND blockchain = ?", a.Address, a.Blockchain)
+	if res.Error != nil {
+		return backendError(res.Error)
+	}
+	if res.RowsAffected > 0 {
+		return ErrAddressExists
+	}
+	if startBlock == 0 {
+		c, err := GetHealthyBlockchainClient(ctx, a.Blockchain)
+		if err != nil {
+			return err
+		}
+		rctx, cancel := context.WithTimeout(ctx, RPCTimeout())
+		head, err := c.BlockNumber(rctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		startBlock = head
+	}
+	a.LastBlock = startBlock
+	return backendError(DB.WithContext(ctx).Create(a).Error)
+}
+
+// ScanAddresses scans up to AddressScanBlocks blocks of a blockchain after the
+// last scanned block and adds the transactions sent from watched addresses to
+// the monitor, with the address in their metadata
+func ScanAddresses(ctx context.Context, name string) error {
+	l := log.WithFields(log.Fields{
+		"action":     "ScanAddresses",
+		"blockchain": name,
+	})
+	var addrs []WatchedAddress
+	if err := DB.WithContext(ctx).Where("blockchain = ?", name).Find(&addrs).Error; err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return nil
+	}
+	c, chainID, err := healthyClient(ctx, name)
+	if err != nil {
+		return err
+	}
+	rctx, cancel := context.WithTimeout(ctx, RPCTimeout())
+	head, err := c.BlockNumber(rctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	from := addrs[0].LastBlock
+	// watched maps each address to its registrations by each tenant
+	watched := make(map[string][]WatchedAddress, len(addrs))
+	for _, a := range addrs {
+		watched[a.Address] = append(watched[a.Address], a)
+		if a.LastBlock < from {
+			from = a.LastBlock
+		}
+	}
+	to := head
+	if to > from+AddressScanBlocks() {
+		to = from + AddressScanBlocks()
+	}
+	signer := types.LatestSignerForChainID(chainID)
+	found := 0
+	for n := from + 1; n <= to; n++ {
+		rctx, cancel := context.WithTimeout(ctx, RPCTimeout())
+		b, err := c.BlockByNumber(rctx, new(big.Int).SetUint64(n))
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, tx := range b.Transactions() {
+			sender, err := types.Sender(signer, tx)
+			if err != nil {
+				continue
+			}
+			for _, a := range watched[sender.Hex()] {
+				if n <= a.LastBlock {
+					continue
+				}
+				t := &Transaction{
+					ID:         tx.Hash().Hex(),
+					TenantID:   a.TenantID,
+					Blockchain: name,
+					Metadata:   MetadataMap{"watchedAddress": sender.Hex()},
+				}
+				if err := t.New(ctx); err != nil && err != ErrExists {
+					l.Printf("txid=%s error %v", t.ID, err)
+					continue
+				}
+				found++
+			}
+		}
+		if err := DB.WithContext(ctx).Model(&WatchedAddress{}).
+			Where("blockchain = ? AND last_block < ?", name, n).
+			Update("last_block", n).Error; err != nil {
+			return err
+		}
+	}
+	l.Printf("from=%d to=%d addresses=%d transactions=%d", from+1, to, len(addrs), found)
+	return nil
+}
+
+// AddressWatcher runs ScanAddresses for every configured blockchain on the
+// provided interval until ctx is cancelled. It returns immediately when
+// ADDRESS_SCAN_BLOCKS is not set
+func AddressWatcher(ctx context.Context, interval time.Duration) {
+	if AddressScanBlocks() == 0 {
+		return
+	}
+	for {
+		for _, name := range BlockchainNames() {
+			if err := ScanAddresses(ctx, name); err != nil {
+				log.WithFields(log.Fields{
+					"action":     "AddressWatcher",
+					"blockchain": name,
+				}).Printf("error %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
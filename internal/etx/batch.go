@@ -0,0 +1,73 @@
+package etx
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+// receiptBatchSize is the maximum number of receipts requested in a single batch
+const receiptBatchSize = 100
+
+// batchReceipts requests the receipts of the provided transactions on a single
+// blockchain using batched RPC calls, and attaches the receipts which were found
+// so that CheckSuccess does not have to request them individually. If the
+// provider rejects batching the transactions are left unchanged and
+// CheckSuccess falls back to individual calls
+func batchReceipts(ctx context.Context, name string, txs []*Transaction) {
+	l := log.WithFields(log.Fields{
+		"action":     "batchReceipts",
+		"blockchain": name,
+	})
+	c, err := GetHealthyBlockchainClient(ctx, name)
+	if err != nil {
+		return
+	}
+	rc := RPCClient(c)
+	if rc == nil {
+		return
+	}
+	for start := 0; start < len(txs); start += receiptBatchSize {
+		end := start + receiptBatchSize
+		if end > len(txs) {
+			end = len(txs)
+		}
+		chunk := txs[start:end]
+		receipts := make([]*types.Receipt, len(chunk))
+		elems := make([]rpc.BatchElem, len(chunk))
+		for i, t := range chunk {
+			elems[i] = rpc.BatchElem{
+				Method: "eth_getTransactionReceipt",
+				Args:   []interface{}{t.ID},
+				Result: &receipts[i],
+			}
+		}
+		rctx, done := rpcContext(ctx, "eth.BatchTransactionReceipt")
+		err := rc.BatchCallContext(rctx, elems)
+		done(err)
+		if err != nil {
+			l.Printf("batch rejected, falling back to individual calls: %v", err)
+			return
+		}
+		for i, t := range chunk {
+			if elems[i].Error == nil && receipts[i] != nil {
+				t.receipt = receipts[i]
+			}
+		}
+	}
+	l.Printf("transactions=%d", len(txs))
+}
+
+// BatchReceipts groups transactions by blockchain and prefetches their
+// receipts with one batch per blockchain
+func BatchReceipts(ctx context.Context, txs []*Transaction) {
+	byChain := make(map[string][]*Transaction)
+	for _, t := range txs {
+		byChain[t.Blockchain] = append(byChain[t.Blockchain], t)
+	}
+	for name, ctxs := range byChain {
+		batchReceipts(ctx, name, ctxs)
+	}
+}
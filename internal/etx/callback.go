@@ -0,0 +1,103 @@
+package etx
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CallbackRetries returns the number of times a failed callback delivery is
+// retried, configured with CALLBACK_RETRIES and defaulting to 3
+func CallbackRetries() int {
+	cr, cerr := strconv.Atoi(os.Getenv("CALLBACK_RETRIES"))
+	if cerr != nil || cr < 0 {
+		return 3
+	}
+	return cr
+}
+
+// CallbackTimeout returns the timeout for a single callback delivery,
+// configured in seconds with CALLBACK_TIMEOUT and defaulting to 10 seconds
+func CallbackTimeout() time.Duration {
+	ct, cerr := strconv.Atoi(os.Getenv("CALLBACK_TIMEOUT"))
+	if cerr != nil || ct <= 0 {
+		return time.Second * 10
+	}
+	return time.Second * time.Duration(ct)
+}
+
+// signatureHeader carries the HMAC signature of a callback body
+const signatureHeader = "X-Txwatch-Signature"
+
+// CallbackSignature returns the signature of a callback body, in the form of
+// "sha256=<hex HMAC-SHA256 of the body keyed with secret>"
+func CallbackSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postCallback sends a single callback request and returns the response status.
+// The body is signed when CALLBACK_SECRET is set
+func postCallback(c *http.Client, url string, body []byte) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := os.Getenv("CALLBACK_SECRET"); secret != "" {
+		req.Header.Set(signatureHeader, CallbackSignature(secret, body))
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.Status, fmt.Errorf("callback returned %s", res.Status)
+	}
+	return res.Status, nil
+}
+
+// SendCallback POSTs the transaction JSON to its CallbackURL. Delivery is best-effort
+// and retried up to CallbackRetries times, with the final result recorded in CallbackStatus
+func (t *Transaction) SendCallback() error {
+	l := log.WithFields(log.Fields{
+		"action": "transaction.SendCallback",
+		"txid":   t.ID,
+	})
+	jd, jerr := json.Marshal(t)
+	if jerr != nil {
+		l.Printf("error %v", jerr)
+		return jerr
+	}
+	c := &http.Client{Timeout: CallbackTimeout()}
+	retries := CallbackRetries()
+	var status string
+	var err error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Second * time.Duration(i))
+		}
+		status, err = postCallback(c, t.CallbackURL, jd)
+		if err == nil {
+			break
+		}
+		l.Printf("attempt=%d error %v", i+1, err)
+	}
+	if err != nil && status == "" {
+		status = err.Error()
+	}
+	l.Printf("status=%s", status)
+	DB.Model(&Transaction{}).Where("id = ?", t.ID).Update("callback_status", status)
+	return err
+}
@@ -0,0 +1,117 @@
+package etx
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSendCallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  string
+		statuses []int
+		status   string
+		fail     bool
+	}{
+		{"delivered", "0", []int{http.StatusOK}, "200 OK", false},
+		{"rejected", "0", []int{http.StatusInternalServerError}, "500 Internal Server Error", true},
+		{"retried", "1", []int{http.StatusBadGateway, http.StatusNoContent}, "204 No Content", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CALLBACK_RETRIES", tt.retries)
+			setupTestDB(t)
+			var payloads []map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+					t.Errorf("got Content-Type %q, want application/json", ct)
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				p := map[string]interface{}{}
+				if err := json.Unmarshal(body, &p); err != nil {
+					t.Errorf("decoding callback %q: %v", body, err)
+				}
+				payloads = append(payloads, p)
+				w.WriteHeader(tt.statuses[len(payloads)-1])
+			}))
+			defer srv.Close()
+			ct := newTestTransaction(t, testTxID("a"), "")
+			ct.CallbackURL = srv.URL
+			ct.Monitoring = false
+			ct.Success = true
+			if err := ct.SendCallback(); (err != nil) != tt.fail {
+				t.Errorf("SendCallback returned %v, want failure %v", err, tt.fail)
+			}
+			if len(payloads) != len(tt.statuses) {
+				t.Fatalf("got %d deliveries, want %d", len(payloads), len(tt.statuses))
+			}
+			p := payloads[0]
+			if p["txid"] != ct.ID || p["blockchain"] != "eth" || p["success"] != true || p["monitoring"] != false {
+				t.Errorf("got payload %v, want the resolved transaction", p)
+			}
+			st := &Transaction{}
+			DB.Find(st, "id = ?", ct.ID)
+			if st.CallbackStatus != tt.status {
+				t.Errorf("got callback status %q, want %q", st.CallbackStatus, tt.status)
+			}
+		})
+	}
+}
+
+func TestCallbackSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   string
+	}{
+		{"payload", "secret", `{"id":"abc"}`, "sha256=4a735a43e7ae6518589db067fa7af9c75fcda827a52e0dc5e455f7031695ef70"},
+		{"empty body", "key", "", "sha256=5d5d139563c95b5967b9bd9a8c9b233a9dedb45072794cd232dc1b74832607d0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CallbackSignature(tt.secret, []byte(tt.body)); got != tt.want {
+				t.Errorf("CallbackSignature(%q, %q) = %q, want %q", tt.secret, tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendCallbackSignature(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"signed", "secret"},
+		{"unsigned", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CALLBACK_RETRIES", "0")
+			t.Setenv("CALLBACK_SECRET", tt.secret)
+			setupTestDB(t)
+			var body []byte
+			var sig string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = ioutil.ReadAll(r.Body)
+				sig = r.Header.Get(signatureHeader)
+			}))
+			defer srv.Close()
+			ct := newTestTransaction(t, testTxID("a"), "")
+			ct.CallbackURL = srv.URL
+			if err := ct.SendCallback(); err != nil {
+				t.Fatal(err)
+			}
+			want := ""
+			if tt.secret != "" {
+				want = CallbackSignature(tt.secret, body)
+			}
+			if sig != want {
+				t.Errorf("got %s %q, want %q", signatureHeader, sig, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package etx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// Clients contains the configured clients for each blockchain, in
+	// failover order
+	Clients = make(map[string][]*ethclient.Client)
+
+	// rpcClients maps each client to its underlying RPC client
+	rpcClients = make(map[*ethclient.Client]*rpc.Client)
+
+	// clientIndex tracks the client currently in use for each blockchain
+	clientIndex   = make(map[string]int)
+	clientIndexMu sync.Mutex
+
+	// chainIDs caches the chain ID last reported for each blockchain
+	chainIDs   = make(map[string]*big.Int)
+	chainIDsMu sync.Mutex
+)
+
+var (
+	// ErrClientNotFound is returned when a blockchain has no configured client
+	ErrClientNotFound = errors.New("blockchain client not found")
+	// ErrNoHealthyClient is returned when every client for a blockchain is failing
+	ErrNoHealthyClient = errors.New("no healthy blockchain client")
+)
+
+// AddBlockchainClient appends a client for the RPC connection to the
+// failover list of a blockchain
+func AddBlockchainClient(name string, rc *rpc.Client) {
+	c := ethclient.NewClient(rc)
+	rpcClients[c] = rc
+	Clients[name] = append(Clients[name], c)
+}
+
+// AddBlockchain configures a blockchain without adding a client, for example
+// when none of its endpoints could be dialed. Its transactions can still be
+// watched, and their checks fail with ErrNoHealthyClient and are retried
+func AddBlockchain(name string) {
+	if _, ok := Clients[name]; !ok {
+		Clients[name] = nil
+	}
+}
+
+// RPCClient returns the RPC connection underlying a client
+func RPCClient(c *ethclient.Client) *rpc.Client {
+	return rpcClients[c]
+}
+
+// BlockchainNames returns the sorted names of the configured blockchains
+func BlockchainNames() []string {
+	names := make([]string, 0, len(Clients))
+	for name := range Clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetBlockchainClient returns the client currently in use for a blockchain
+func GetBlockchainClient(name string) (*ethclient.Client, error) {
+	cs, ok := Clients[name]
+	if !ok {
+		return nil, ErrClientNotFound
+	} else if len(cs) == 0 {
+		return nil, ErrNoHealthyClient
+	}
+	clientIndexMu.Lock()
+	defer clientIndexMu.Unlock()
+	return cs[clientIndex[name]%len(cs)], nil
+}
+
+// GetHealthyBlockchainClient returns a healthy client for a blockchain. If the
+// current client fails its ChainID check, the next configured client is tried
+// until one responds or all have been tried
+func GetHealthyBlockchainClient(ctx context.Context, name string) (*ethclient.Client, error) {
+	c, _, err := healthyClient(ctx, name)
+	return c, err
+}
+
+// BlockchainChainID returns the chain ID reported by a healthy client for a blockchain
+func BlockchainChainID(ctx context.Context, name string) (*big.Int, error) {
+	_, id, err := healthyClient(ctx, name)
+	return id, err
+}
+
+// VerifyChainIDs checks that every client of the blockchains in expected reports
+// the expected chain ID, returning an error on the first mismatch. Clients which
+// cannot be reached are logged and skipped so that an endpoint outage does not
+// prevent startup
+func VerifyChainIDs(ctx context.Context, expected map[string]*big.Int) error {
+	for name, want := range expected {
+		l := log.WithFields(log.Fields{
+			"action":     "VerifyChainIDs",
+			"blockchain": name,
+		})
+		cs, ok := Clients[name]
+		if !ok {
+			l.Warnf("chain id configured for unknown blockchain")
+			continue
+		}
+		for idx, c := range cs {
+			rctx, cancel := context.WithTimeout(ctx, RPCTimeout())
+			id, err := c.ChainID(rctx)
+			cancel()
+			if err != nil {
+				l.Warnf("client %d: unable to verify chain id: %v", idx, err)
+				continue
+			}
+			if id.Cmp(want) != 0 {
+				return fmt.Errorf("blockchain %s client %d reports chain id %s, expected %s", name, idx, id, want)
+			}
+			l.Printf("client %d: chain id %s", idx, id)
+		}
+	}
+	return nil
+}
+
+// CachedChainID returns the chain ID last reported by a healthy client for
+// a blockchain without calling the client, or nil if it is not yet known
+func CachedChainID(name string) *big.Int {
+	chainIDsMu.Lock()
+	defer chainIDsMu.Unlock()
+	return chainIDs[name]
+}
+
+// CacheChainIDs caches the chain ID of every blockchain, so that blockchains
+// can be resolved by chain ID. Blockchains which cannot be reached are logged
+// and cached once a client responds
+func CacheChainIDs(ctx context.Context) {
+	for _, name := range BlockchainNames() {
+		if _, err := BlockchainChainID(ctx, name); err != nil {
+			log.WithFields(log.Fields{
+				"action":     "CacheChainIDs",
+				"blockchain": name,
+			}).Warnf("unable to get chain id: %v", err)
+		}
+	}
+}
+
+// ResolveBlockchain returns the name of a blockchain provided either by name or by
+// its numeric chain ID. A chain ID shared by several blockchains resolves to the
+// first by name. Values which match neither are returned unchanged
+func ResolveBlockchain(s string) string {
+	if _, ok := Clients[s]; ok {
+		return s
+	}
+	id, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return s
+	}
+	for _, name := range BlockchainNames() {
+		if cid := CachedChainID(name); cid != nil && cid.Cmp(id) == 0 {
+			return name
+		}
+	}
+	return s
+}
+
+// healthyClient returns the first client for a blockchain which responds to
+// ChainID, starting from the current client, along with the chain ID
+func healthyClient(ctx context.Context, name string) (*ethclient.Client, *big.Int, error) {
+	cs, ok := Clients[name]
+	if !ok {
+		return nil, nil, ErrClientNotFound
+	} else if len(cs) == 0 {
+		return nil, nil, ErrNoHealthyClient
+	}
+	clientIndexMu.Lock()
+	start := clientIndex[name]
+	clientIndexMu.Unlock()
+	for i := 0; i < len(cs); i++ {
+		idx := (start + i) % len(cs)
+		rctx, cancel := context.WithTimeout(ctx, RPCTimeout())
+		id, err := cs[idx].ChainID(rctx)
+		cancel()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"action":     "GetHealthyBlockchainClient",
+				"blockchain": name,
+				"client":     idx,
+			}).Printf("error %v", err)
+			continue
+		}
+		chainIDsMu.Lock()
+		chainIDs[name] = id
+		chainIDsMu.Unlock()
+		if idx != start {
+			log.WithFields(log.Fields{
+				"action":     "GetHealthyBlockchainClient",
+				"blockchain": name,
+			}).Printf("failing over to client %d", idx)
+			clientIndexMu.Lock()
+			clientIndex[name] = idx
+			clientIndexMu.Unlock()
+		}
+		return cs[idx], id, nil
+	}
+	return nil, nil, ErrNoHealthyClient
+}
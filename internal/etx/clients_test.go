@@ -0,0 +1,91 @@
+package etx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// setChainID serves chainID from eth_chainId for a test blockchain
+func setChainID(t *testing.T, name, chainID string) {
+	t.Helper()
+	setupTestRPC(t, name, func(method string, params []json.RawMessage) (interface{}, int) {
+		if method == "eth_chainId" {
+			return chainID, 0
+		}
+		return nil, 0
+	})
+}
+
+// resetChainIDs clears the chain ID cache, restoring it when the test ends
+func resetChainIDs(t *testing.T) {
+	chainIDsMu.Lock()
+	prev := chainIDs
+	chainIDs = make(map[string]*big.Int)
+	chainIDsMu.Unlock()
+	t.Cleanup(func() {
+		chainIDsMu.Lock()
+		chainIDs = prev
+		chainIDsMu.Unlock()
+	})
+}
+
+func TestResolveBlockchain(t *testing.T) {
+	resetChainIDs(t)
+	setChainID(t, "eth", "0x1")
+	setChainID(t, "poly", "0x89")
+	setChainID(t, "eth-fork", "0x1")
+	if got := ResolveBlockchain("137"); got != "137" {
+		t.Errorf("ResolveBlockchain(137) before caching = %q, want it unchanged", got)
+	}
+	CacheChainIDs(context.Background())
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"eth", "eth"},
+		{"poly", "poly"},
+		{"137", "poly"},
+		{"1", "eth"},
+		{"56", "56"},
+		{"bsc", "bsc"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := ResolveBlockchain(tt.in); got != tt.want {
+				t.Errorf("ResolveBlockchain(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateChainID(t *testing.T) {
+	resetChainIDs(t)
+	setChainID(t, "eth", "0x1")
+	setChainID(t, "poly", "0x89")
+	CacheChainIDs(context.Background())
+	tests := []struct {
+		blockchain string
+		want       string
+		err        error
+	}{
+		{"poly", "poly", nil},
+		{"137", "poly", nil},
+		{"1", "eth", nil},
+		{"56", "56", ErrInvalidBlockchain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.blockchain, func(t *testing.T) {
+			vt := &Transaction{ID: testTxID("a"), Blockchain: tt.blockchain}
+			if err := vt.Validate(); !errors.Is(err, tt.err) {
+				t.Fatalf("Validate() = %v, want %v", err, tt.err)
+			}
+			if vt.Blockchain != tt.want {
+				t.Errorf("got blockchain %q, want %q", vt.Blockchain, tt.want)
+			}
+		})
+	}
+}
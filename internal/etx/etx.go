@@ -6,35 +6,113 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
+	"math/rand"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
 var (
-	DB      *gorm.DB
-	Clients = make(map[string]*ethclient.Client)
+	DB *gorm.DB
+	// ReadDB serves read-only API queries, which can be sent to a read
+	// replica. It is DB when no replica is configured
+	ReadDB *gorm.DB
+
+	txHashRegexp = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
 )
 
+var (
+	// ErrNotFound is returned when a transaction does not exist in the database
+	ErrNotFound = errors.New("transaction not found")
+	// ErrExists is returned when creating a transaction which is already watched
+	ErrExists = errors.New("transaction already exists")
+	// ErrConflict is returned when a transaction hash is already watched on another blockchain
+	ErrConflict = errors.New("transaction already exists on another blockchain")
+	// ErrTenantConflict is returned when a transaction hash is already watched by another
+	// tenant. Its message does not mention the other tenant, so that tenants cannot tell
+	// it apart from other reasons a hash is unavailable
+	ErrTenantConflict = errors.New("transaction hash is not available")
+	// ErrInvalidTxID is returned when a transaction hash is not a 0x-prefixed 32 byte hex string
+	ErrInvalidTxID = errors.New("invalid txid")
+	// ErrInvalidBlockchain is returned when a transaction is for a blockchain without a client
+	ErrInvalidBlockchain = errors.New("invalid blockchain")
+	// ErrReverted is returned when requeueing a transaction which reverted on-chain
+	ErrReverted = errors.New("transaction reverted on-chain")
+	// ErrNotFailed is returned when requeueing a transaction which is still monitored or succeeded
+	ErrNotFailed = errors.New("transaction has not failed")
+	// ErrBackend is wrapped around database errors, which are not caused by the request
+	ErrBackend = errors.New("database error")
+)
+
+// backendError wraps a database error with ErrBackend
+func backendError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrBackend, err)
+}
+
 // Transaction contains the data for a single transaction
-// on the Ethereum Blockchain
+// on the Ethereum Blockchain. The transaction hash ID shadows the
+// gorm.Model ID and is the primary key, so a hash can only be watched once
 type Transaction struct {
 	gorm.Model
-	ID         string      `json:"txid"`
-	Blockchain string      `json:"blockchain"`
-	Metadata   MetadataMap `json:"metadata"`
-	Monitoring bool        `json:"monitoring"`
-	Pending    bool        `json:"pending"`
-	Checks     int         `json:"checks"`
-	Success    bool        `json:"success"`
-	Reviewed   bool        `json:"reviewed"`
-	Error      string      `json:"error"`
+	ID               string      `json:"txid" gorm:"primaryKey"`
+	TenantID         string      `json:"tenantId" gorm:"index"`
+	Blockchain       string      `json:"blockchain" gorm:"index"`
+	Metadata         MetadataMap `json:"metadata"`
+	Monitoring       bool        `json:"monitoring"`
+	Pending          bool        `json:"pending"`
+	Checks           int         `json:"checks"`
+	ConnectionErrors int         `json:"connectionErrors"`
+	// Confirmations is the number of blocks mined on top of the
+	// block containing the transaction at the last check
+	Confirmations     int            `json:"confirmations"`
+	Success           bool           `json:"success"`
+	Reviewed          bool           `json:"reviewed"`
+	Error             string         `json:"error"`
+	Dropped           bool           `json:"dropped"`
+	Logs              ReceiptLogs    `json:"logs"`
+	DecodeTransfers   bool           `json:"decodeTransfers"`
+	Transfers         TokenTransfers `json:"transfers"`
+	BlockNumber       uint64         `json:"blockNumber"`
+	GasUsed           uint64         `json:"gasUsed"`
+	EffectiveGasPrice string         `json:"effectiveGasPrice"`
+	NextCheckAt       time.Time      `json:"nextCheckAt"`
+	FromAddress       string         `json:"from"`
+	ToAddress         string         `json:"to"`
+	Value             string         `json:"value"`
+	TxType            *uint8         `json:"txType"`
+	GasFeeCap         string         `json:"gasFeeCap"`
+	GasTipCap         string         `json:"gasTipCap"`
+	CallbackURL       string         `json:"callbackUrl"`
+	CallbackStatus    string         `json:"callbackStatus"`
+	ResolvedAt        *time.Time     `json:"resolvedAt"`
+	DeadlineAt        *time.Time     `json:"deadlineAt"`
+	DeadlineBlock     *uint64        `json:"deadlineBlock"`
+	ReplacedBy        string         `json:"replacedBy"`
+
+	// receipt is the receipt prefetched by BatchReceipts, if any
+	receipt *types.Receipt
+	// savedState is the State the transaction was loaded or last saved with
+	savedState string
 }
 
 type MetadataMap map[string]string
@@ -51,12 +129,53 @@ func (m *MetadataMap) Scan(value interface{}) error {
 	return json.Unmarshal(b, m)
 }
 
-func GetBlockchainClient(name string) (*ethclient.Client, error) {
-	var c *ethclient.Client
-	if c, ok := Clients[name]; ok {
-		return c, nil
+// ReceiptLog is a hex-encoded representation of a log emitted by a transaction
+type ReceiptLog struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
+}
+
+type ReceiptLogs []ReceiptLog
+
+// NewReceiptLogs converts the logs from a transaction receipt into ReceiptLogs
+func NewReceiptLogs(logs []*types.Log) ReceiptLogs {
+	rl := make(ReceiptLogs, 0, len(logs))
+	for _, l := range logs {
+		topics := make([]string, len(l.Topics))
+		for i, tp := range l.Topics {
+			topics[i] = tp.Hex()
+		}
+		rl = append(rl, ReceiptLog{
+			Address: l.Address.Hex(),
+			Topics:  topics,
+			Data:    hexutil.Encode(l.Data),
+		})
+	}
+	return rl
+}
+
+func (ReceiptLogs) GormDataType() string {
+	return "bytes"
+}
+
+func (l ReceiptLogs) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
+func (l *ReceiptLogs) Scan(value interface{}) error {
+	if value == nil {
+		*l = nil
+		return nil
 	}
-	return c, errors.New("blockchain client not found")
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("[]byte assertion failed")
+	}
+	return json.Unmarshal(b, l)
 }
 
 // ChecksThreshold will automatically mark a transaction as failed if it
@@ -66,12 +185,7 @@ func (t *Transaction) ChecksThreshold() {
 		"action": "transaction.ChecksThreshold",
 		"txid":   t.ID,
 	}).Printf("checks=%d", t.Checks)
-	sc, serr := strconv.Atoi(os.Getenv("CHECKS_THRESHOLD"))
-	if serr != nil {
-		log.Printf("error %v", serr)
-		return
-	}
-	if t.Checks > sc {
+	if t.Checks > MaxChecks(t.Blockchain) {
 		t.Error = "exceeded checks threshold"
 		t.Monitoring = false
 		t.Pending = false
@@ -79,25 +193,438 @@ func (t *Transaction) ChecksThreshold() {
 	}
 }
 
-// Save saves a transaction in the database. If the number of checks exceeds the ChecksThreshold
-// it will mark the transaction as failed
-func (t *Transaction) Save() error {
+// ExpireDeadlines fails the transactions which are still monitored after their
+// DeadlineAt, or once their blockchain reaches their DeadlineBlock, with the
+// "deadline exceeded" error, which sends them to the configured Notifiers.
+// Unlike the checks threshold, deadlines are wall-clock or block-height based,
+// so that transactions can be held to an SLA
+func ExpireDeadlines(ctx context.Context, now time.Time) error {
+	err := expireDeadlines(ctx, func(db *gorm.DB) *gorm.DB {
+		return db.Where("deadline_at IS NOT NULL AND deadline_at <= ?", now)
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range BlockchainNames() {
+		// only look up the head of blockchains with block deadlines
+		var ids []string
+		err := DB.WithContext(ctx).Model(&Transaction{}).
+			Where("monitoring = ? AND blockchain = ? AND deadline_block IS NOT NULL", true, name).
+			Limit(1).Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		head, err := blockchainHead(ctx, name)
+		if err != nil {
+			// block deadlines of this blockchain are expired on a later run
+			log.WithFields(log.Fields{
+				"action":     "ExpireDeadlines",
+				"blockchain": name,
+			}).Printf("error %v", err)
+			continue
+		}
+		err = expireDeadlines(ctx, func(db *gorm.DB) *gorm.DB {
+			return db.Where("blockchain = ? AND deadline_block IS NOT NULL AND deadline_block <= ?", name, head)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireDeadlines fails the monitored transactions selected by expired, loading
+// them in pages of MonitorPageSize ordered by ID
+func expireDeadlines(ctx context.Context, expired func(db *gorm.DB) *gorm.DB) error {
+	pageSize := MonitorPageSize()
+	var afterID string
+	for {
+		var txs []Transaction
+		err := DB.WithContext(ctx).Scopes(expired).
+			Where("monitoring = ? AND id > ?", true, afterID).
+			Order("id").
+			Limit(pageSize).
+			Find(&txs).Error
+		if err != nil {
+			return err
+		}
+		for i := range txs {
+			t := &txs[i]
+			log.WithFields(log.Fields{
+				"action": "ExpireDeadlines",
+				"txid":   t.ID,
+			}).Printf("deadlineAt=%v deadlineBlock=%v", t.DeadlineAt, t.DeadlineBlock)
+			t.Error = "deadline exceeded"
+			t.Monitoring = false
+			t.Pending = false
+			t.Success = false
+			if serr := t.Save(ctx); serr != nil {
+				log.WithFields(log.Fields{
+					"action": "ExpireDeadlines",
+					"txid":   t.ID,
+				}).Printf("error %v", serr)
+			}
+		}
+		if len(txs) < pageSize {
+			return nil
+		}
+		afterID = txs[len(txs)-1].ID
+	}
+}
+
+// blockchainHead returns the latest block number of a blockchain
+func blockchainHead(ctx context.Context, name string) (uint64, error) {
+	c, err := GetHealthyBlockchainClient(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	var head uint64
+	err = retryRPC(ctx, "eth.BlockNumber", func(rctx context.Context) (err error) {
+		head, err = c.BlockNumber(rctx)
+		return err
+	})
+	return head, err
+}
+
+// defaultChecksThreshold is used when CHECKS_THRESHOLD is not configured
+const defaultChecksThreshold = 100
+
+var (
+	// checksThreshold is the global checks threshold, loaded by ConfigureChecksThresholds
+	checksThreshold = defaultChecksThreshold
+	// checksThresholds holds the per blockchain overrides of checksThreshold
+	checksThresholds = make(map[string]int)
+)
+
+// parseChecksThreshold parses a checks threshold env var, returning false
+// if it is unset or invalid
+func parseChecksThreshold(k string) (int, bool) {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0, false
+	}
+	sc, serr := strconv.Atoi(v)
+	if serr != nil || sc <= 0 {
+		log.Printf("error invalid %s=%q", k, v)
+		return 0, false
+	}
+	return sc, true
+}
+
+// ConfigureChecksThresholds loads CHECKS_THRESHOLD and the CHECKS_THRESHOLD_<name>
+// overrides of the configured blockchains. It must be called after the
+// blockchain clients have been added
+func ConfigureChecksThresholds() {
+	checksThreshold = defaultChecksThreshold
+	if sc, ok := parseChecksThreshold("CHECKS_THRESHOLD"); ok {
+		checksThreshold = sc
+	}
+	checksThresholds = make(map[string]int)
+	for _, name := range BlockchainNames() {
+		if sc, ok := parseChecksThreshold("CHECKS_THRESHOLD_" + name); ok {
+			checksThresholds[name] = sc
+		}
+	}
 	log.WithFields(log.Fields{
+		"action": "ConfigureChecksThresholds",
+	}).Printf("default=%d overrides=%v", checksThreshold, checksThresholds)
+}
+
+// MaxChecks returns the number of checks after which a transaction of a blockchain
+// is marked as failed
+func MaxChecks(name string) int {
+	if sc, ok := checksThresholds[name]; ok {
+		return sc
+	}
+	return checksThreshold
+}
+
+// ConfirmationsRequired returns the number of block confirmations a mined
+// transaction must have before it is considered resolved
+func ConfirmationsRequired() int {
+	cr, cerr := strconv.Atoi(os.Getenv("CONFIRMATIONS_REQUIRED"))
+	if cerr != nil || cr < 0 {
+		return 0
+	}
+	return cr
+}
+
+// CheckBackoff returns the delay before a transaction which has been checked
+// the provided number of times is checked again. The delay starts at
+// CHECK_BACKOFF_BASE seconds (default 1) and doubles with each check up to
+// CHECK_BACKOFF_MAX seconds (default 600)
+func CheckBackoff(checks int) time.Duration {
+	base, berr := strconv.Atoi(os.Getenv("CHECK_BACKOFF_BASE"))
+	if berr != nil || base < 0 {
+		base = 1
+	}
+	max, merr := strconv.Atoi(os.Getenv("CHECK_BACKOFF_MAX"))
+	if merr != nil || max < 0 {
+		max = 600
+	}
+	if checks < 1 {
+		checks = 1
+	}
+	if checks > 31 {
+		checks = 31
+	}
+	d := time.Second * time.Duration(base) * time.Duration(1<<uint(checks-1))
+	if m := time.Second * time.Duration(max); d > m || d < 0 {
+		return m
+	}
+	return d
+}
+
+// Save saves a transaction in the database. If the number of checks exceeds the ChecksThreshold
+// it will mark the transaction as failed, and ResolvedAt is set once the transaction
+// leaves monitoring. State changes are recorded in the transaction history. The saved
+// state is published to the Updates hub and once a transaction leaves monitoring its
+// callback, if any, is sent in the background. Failed transactions are sent to the
+// configured Notifiers
+func (t *Transaction) Save(ctx context.Context) error {
+	l := log.WithFields(log.Fields{
 		"action": "transaction.Save",
 		"txid":   t.ID,
-	}).Printf("%+v", t)
+	})
+	l.Debugf("%+v", t)
 	t.ChecksThreshold()
+	t.NextCheckAt = time.Now().Add(CheckBackoff(t.Checks))
+	if t.Monitoring {
+		t.ResolvedAt = nil
+	} else if t.ResolvedAt == nil {
+		now := time.Now()
+		t.ResolvedAt = &now
+	}
 	ut := map[string]interface{}{
-		"success":    t.Success,
-		"pending":    t.Pending,
-		"error":      t.Error,
-		"monitoring": t.Monitoring,
-		"checks":     t.Checks,
+		"success":             t.Success,
+		"pending":             t.Pending,
+		"error":               t.Error,
+		"monitoring":          t.Monitoring,
+		"checks":              t.Checks,
+		"connection_errors":   t.ConnectionErrors,
+		"dropped":             t.Dropped,
+		"next_check_at":       t.NextCheckAt,
+		"from_address":        t.FromAddress,
+		"to_address":          t.ToAddress,
+		"value":               t.Value,
+		"tx_type":             t.TxType,
+		"gas_fee_cap":         t.GasFeeCap,
+		"gas_tip_cap":         t.GasTipCap,
+		"confirmations":       t.Confirmations,
+		"logs":                t.Logs,
+		"transfers":           t.Transfers,
+		"block_number":        t.BlockNumber,
+		"gas_used":            t.GasUsed,
+		"effective_gas_price": t.EffectiveGasPrice,
+		"resolved_at":         t.ResolvedAt,
+	}
+	err := retryDB(ctx, l, func() error {
+		return DB.WithContext(ctx).Model(&Transaction{}).Where("id = ?", t.ID).Updates(ut).Error
+	})
+	if err != nil {
+		return backendError(err)
+	}
+	t.recordStateChange(ctx)
+	Updates.Publish(*t)
+	if !t.Monitoring && !t.Success {
+		Notify(*t)
+	}
+	if !t.Monitoring && t.CallbackURL != "" {
+		ct := *t
+		go ct.SendCallback()
 	}
-	DB.Find(&Transaction{ID: t.ID}).Updates(ut)
 	return nil
 }
 
+// saveResult saves a transaction whose check ended with err, returning err
+// unless the transaction could not be saved
+func (t *Transaction) saveResult(ctx context.Context, err error) error {
+	if serr := t.Save(ctx); serr != nil {
+		return serr
+	}
+	return err
+}
+
+// RPCTimeout returns the timeout applied to each blockchain RPC call,
+// configured in seconds with RPC_TIMEOUT and defaulting to 10 seconds
+func RPCTimeout() time.Duration {
+	rt, rerr := strconv.Atoi(os.Getenv("RPC_TIMEOUT"))
+	if rerr != nil || rt <= 0 {
+		return time.Second * 10
+	}
+	return time.Second * time.Duration(rt)
+}
+
+// retryLater logs an error and keeps the transaction pending and monitored so
+// that it is checked again on the next run. Connection errors are counted in
+// ConnectionErrors rather than Checks, so that an outage of the blockchain's
+// endpoints does not use up the checks threshold. Other errors count as a check
+func (t *Transaction) retryLater(ctx context.Context, err error) error {
+	log.WithFields(log.Fields{
+		"action": "transaction.retryLater",
+		"txid":   t.ID,
+	}).Printf("error %v", err)
+	if isConnectionError(err) {
+		t.Checks--
+		t.ConnectionErrors++
+	}
+	t.Pending = true
+	t.Monitoring = true
+	return t.saveResult(ctx, err)
+}
+
+// DroppedGraceChecks returns the number of checks during which a transaction
+// not found on chain is still monitored, in case it has not yet propagated.
+// Configured with DROPPED_GRACE_CHECKS and defaulting to 5
+func DroppedGraceChecks() int {
+	dg, derr := strconv.Atoi(os.Getenv("DROPPED_GRACE_CHECKS"))
+	if derr != nil || dg < 0 {
+		return 5
+	}
+	return dg
+}
+
+// DroppedGracePeriod returns how long after it was added a transaction not found
+// on chain is still monitored, in addition to the DroppedGraceChecks window.
+// Configured with DROPPED_GRACE_PERIOD as a duration such as "2m" or a number of
+// seconds. Zero, the default, only applies the check count
+func DroppedGracePeriod() time.Duration {
+	gp := os.Getenv("DROPPED_GRACE_PERIOD")
+	if gp == "" {
+		return 0
+	}
+	d, derr := time.ParseDuration(gp)
+	if derr != nil {
+		if s, serr := strconv.Atoi(gp); serr == nil {
+			d, derr = time.Second*time.Duration(s), nil
+		}
+	}
+	if derr != nil || d < 0 {
+		log.WithFields(log.Fields{
+			"action": "DroppedGracePeriod",
+		}).Warnf("invalid DROPPED_GRACE_PERIOD %q, ignoring", gp)
+		return 0
+	}
+	return d
+}
+
+// inDroppedGrace reports whether a transaction not found on chain is still
+// within the grace window, either by checks or by age
+func (t *Transaction) inDroppedGrace() bool {
+	if t.Checks <= DroppedGraceChecks() {
+		return true
+	}
+	return time.Since(t.CreatedAt) < DroppedGracePeriod()
+}
+
+// notFound handles a transaction which is not known to the blockchain client.
+// It is kept pending during the grace window and is marked as dropped once
+// the window has passed
+func (t *Transaction) notFound(ctx context.Context) error {
+	log.WithFields(log.Fields{
+		"action": "transaction.notFound",
+		"txid":   t.ID,
+	}).Printf("checks=%d grace=%d gracePeriod=%s", t.Checks, DroppedGraceChecks(), DroppedGracePeriod())
+	if t.inDroppedGrace() {
+		t.Pending = true
+		t.Monitoring = true
+		return t.Save(ctx)
+	}
+	t.Pending = false
+	t.Monitoring = false
+	t.Success = false
+	t.Dropped = true
+	t.Error = "dropped"
+	return t.saveResult(ctx, ethereum.NotFound)
+}
+
+// effectiveGasPrice returns the price per gas paid by a mined transaction.
+// Dynamic fee transactions require the base fee of the block they were mined in
+func effectiveGasPrice(ctx context.Context, c *ethclient.Client, tx *types.Transaction, r *types.Receipt) (*big.Int, error) {
+	if tx.Type() != types.DynamicFeeTxType {
+		return tx.GasPrice(), nil
+	}
+	h, err := c.HeaderByHash(ctx, r.BlockHash)
+	if err != nil {
+		return nil, err
+	}
+	if h.BaseFee == nil {
+		return tx.GasPrice(), nil
+	}
+	tip, err := tx.EffectiveGasTip(h.BaseFee)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Add(h.BaseFee, tip), nil
+}
+
+// revertReason re-simulates a reverted transaction on the state of the block
+// before the one it was mined in, since eth_call runs on the state after a block,
+// and decodes the Error(string) revert reason. An empty reason is returned if the
+// revert has no reason or the simulation does not revert
+func revertReason(ctx context.Context, c *ethclient.Client, tx *types.Transaction, r *types.Receipt, from common.Address) (string, error) {
+	// gas prices are left out so the simulation does not depend on the
+	// balance of the sender
+	msg := ethereum.CallMsg{
+		From:       from,
+		To:         tx.To(),
+		Gas:        tx.Gas(),
+		Value:      tx.Value(),
+		Data:       tx.Data(),
+		AccessList: tx.AccessList(),
+	}
+	_, err := c.CallContract(ctx, msg, new(big.Int).Sub(r.BlockNumber, big.NewInt(1)))
+	if err == nil {
+		return "", nil
+	}
+	var de rpc.DataError
+	if !errors.As(err, &de) {
+		return "", err
+	}
+	ed, ok := de.ErrorData().(string)
+	if !ok {
+		return "", nil
+	}
+	data, err := hexutil.Decode(ed)
+	if err != nil {
+		return "", err
+	}
+	reason, err := abi.UnpackRevert(data)
+	if err != nil {
+		// custom errors and panics are not Error(string)
+		return "", nil
+	}
+	return reason, nil
+}
+
+// setTxDetails records the sender, recipient, value and type of a transaction.
+// Contract creation transactions have no recipient, and only dynamic fee
+// transactions have fee caps. TxType is nil until the transaction is found
+func (t *Transaction) setTxDetails(tx *types.Transaction) {
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"action": "transaction.setTxDetails",
+			"txid":   t.ID,
+		}).Printf("error %v", err)
+	} else {
+		t.FromAddress = from.Hex()
+	}
+	if to := tx.To(); to != nil {
+		t.ToAddress = to.Hex()
+	}
+	t.Value = tx.Value().String()
+	txType := tx.Type()
+	t.TxType = &txType
+	if txType == types.DynamicFeeTxType {
+		t.GasFeeCap = tx.GasFeeCap().String()
+		t.GasTipCap = tx.GasTipCap().String()
+	}
+}
+
 // CheckSuccess checks whether a transaction is pending, errored, or successful
 // and logs the state in the database.
 func (t *Transaction) CheckSuccess(ctx context.Context) error {
@@ -106,104 +633,481 @@ func (t *Transaction) CheckSuccess(ctx context.Context) error {
 		"txid":   t.ID,
 		"checks": t.Checks,
 	}).Print("")
+	ctx, span := tracer.Start(ctx, "transaction.CheckSuccess", trace.WithAttributes(
+		attribute.String("txid", t.ID),
+		attribute.String("blockchain", t.Blockchain),
+	))
+	defer span.End()
 	t.Checks++
 	txHash := common.HexToHash(t.ID)
-	c, cerr := GetBlockchainClient(t.Blockchain)
-	if cerr != nil {
-		return cerr
+	c, cerr := GetHealthyBlockchainClient(ctx, t.Blockchain)
+	if cerr == ErrClientNotFound {
+		// the blockchain was removed from the config, so stop monitoring
+		// rather than selecting the transaction forever
+		log.Println(cerr)
+		t.Pending = false
+		t.Monitoring = false
+		t.Error = ErrClientNotFound.Error()
+		return t.saveResult(ctx, cerr)
+	} else if cerr != nil {
+		return t.retryLater(ctx, cerr)
 	}
-	tx, isPending, err := c.TransactionByHash(ctx, txHash)
+	release, aerr := acquireRPC(ctx, c)
+	if aerr != nil {
+		return t.retryLater(ctx, aerr)
+	}
+	defer release()
+	var tx *types.Transaction
+	var isPending bool
+	err := retryRPC(ctx, "eth.TransactionByHash", func(rctx context.Context) (err error) {
+		tx, isPending, err = c.TransactionByHash(rctx, txHash)
+		return err
+	})
 	if err != nil {
+		if isTransient(err) {
+			return t.retryLater(ctx, err)
+		}
+		if err == ethereum.NotFound {
+			return t.notFound(ctx)
+		}
 		log.Println(err)
 		t.Pending = false
 		t.Monitoring = false
-		t.Error = err.Error()
-		t.Save()
-		return err
+		t.Error = Redact(err.Error())
+		return t.saveResult(ctx, err)
 	}
+	t.setTxDetails(tx)
 	if isPending {
 		t.Pending = true
 		t.Monitoring = true
 	} else {
 		t.Pending = false
 		t.Monitoring = false
-		r, err := c.TransactionReceipt(ctx, tx.Hash())
+		r := t.receipt
+		if r == nil {
+			err = retryRPC(ctx, "eth.TransactionReceipt", func(rctx context.Context) (err error) {
+				r, err = c.TransactionReceipt(rctx, tx.Hash())
+				return err
+			})
+			if err != nil {
+				if isTransient(err) {
+					return t.retryLater(ctx, err)
+				}
+				log.Println(err)
+				t.Error = Redact(err.Error())
+				return t.saveResult(ctx, err)
+			}
+		}
+		var head uint64
+		err = retryRPC(ctx, "eth.BlockNumber", func(rctx context.Context) (err error) {
+			head, err = c.BlockNumber(rctx)
+			return err
+		})
+		if err != nil {
+			return t.retryLater(ctx, err)
+		}
+		t.BlockNumber = r.BlockNumber.Uint64()
+		if head >= r.BlockNumber.Uint64() {
+			t.Confirmations = int(head - r.BlockNumber.Uint64())
+		}
+		if t.Confirmations < ConfirmationsRequired() {
+			t.Pending = true
+			t.Monitoring = true
+			return t.Save(ctx)
+		}
+		t.Logs = NewReceiptLogs(r.Logs)
+		if t.DecodeTransfers {
+			t.Transfers = NewTokenTransfers(r.Logs)
+		}
+		t.GasUsed = r.GasUsed
+		rctx, done := rpcContext(ctx, "eth.HeaderByHash")
+		gp, err := effectiveGasPrice(rctx, c, tx, r)
+		done(err)
 		if err != nil {
 			log.Println(err)
-			t.Error = err.Error()
-			t.Save()
-			return err
+		} else {
+			t.EffectiveGasPrice = gp.String()
 		}
 		if r.Status > 0 {
 			t.Success = true
 		} else {
-			// Todo capture r.Logs data
 			t.Success = false
 			t.Error = "failure"
+			rctx, done := rpcContext(ctx, "eth.CallContract")
+			reason, err := revertReason(rctx, c, tx, r, common.HexToAddress(t.FromAddress))
+			done(err)
+			if err != nil {
+				log.Println(err)
+			} else if reason != "" {
+				t.Error = "failure: " + reason
+			}
 		}
 	}
-	t.Save()
-	return nil
+	return t.Save(ctx)
 }
 
 // HttpJSON marshals a transaction into a JSON response and sends it through the
 // provided http.ResponseWriter
-func (t *Transaction) HttpJSON(w http.ResponseWriter) {
+func (t *Transaction) HttpJSON(w http.ResponseWriter) error {
+	return t.HttpJSONStatus(w, http.StatusOK)
+}
+
+// HttpJSONStatus marshals a transaction into a JSON response and sends it through the
+// provided http.ResponseWriter with the provided status code. Nothing is written if
+// the transaction cannot be marshalled, so that the caller can send an error instead
+func (t *Transaction) HttpJSONStatus(w http.ResponseWriter, code int) error {
 	log.WithFields(log.Fields{
 		"action": "transaction.HttpJSON",
 		"txid":   t.ID,
 	}).Print("Create response JSON")
 	jd, jerr := json.Marshal(t)
 	if jerr != nil {
-		log.Println(jerr)
-		http.Error(w, jerr.Error(), http.StatusBadRequest)
-		return
+		return jerr
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	fmt.Fprint(w, string(jd))
+	return nil
+}
+
+// ValidateTxID checks that a transaction ID is a 0x-prefixed 32 byte hex hash
+func ValidateTxID(id string) error {
+	if !txHashRegexp.MatchString(id) {
+		return fmt.Errorf("%w %q, must be a 0x-prefixed 32 byte hex string", ErrInvalidTxID, id)
+	}
+	return nil
+}
+
+// Validate checks that the transaction ID is a 0x-prefixed 32 byte hex hash
+// and that its blockchain has a configured client. A blockchain provided by
+// chain ID is replaced with its name
+func (t *Transaction) Validate() error {
+	t.Blockchain = ResolveBlockchain(t.Blockchain)
+	if err := ValidateTxID(t.ID); err != nil {
+		return err
+	}
+	if _, ok := Clients[t.Blockchain]; !ok {
+		return fmt.Errorf("%w %q, must be one of: %s", ErrInvalidBlockchain, t.Blockchain, strings.Join(BlockchainNames(), ", "))
+	}
+	return nil
+}
+
+// LookupResult is the on-chain state of a transaction returned by Lookup
+type LookupResult struct {
+	ID         string `json:"txid"`
+	Blockchain string `json:"blockchain"`
+	Found      bool   `json:"found"`
+	Pending    bool   `json:"pending"`
+}
+
+// Lookup checks whether a transaction exists on its blockchain with a single
+// TransactionByHash call, without storing anything in the database
+func (t *Transaction) Lookup(ctx context.Context) (*LookupResult, error) {
+	log.WithFields(log.Fields{
+		"action": "transaction.Lookup",
+		"txid":   t.ID,
+	}).Printf("blockchain=%s", t.Blockchain)
+	c, err := GetBlockchainClient(t.Blockchain)
+	if err != nil {
+		return nil, err
+	}
+	lr := &LookupResult{ID: t.ID, Blockchain: t.Blockchain}
+	rctx, done := rpcContext(ctx, "eth.TransactionByHash")
+	_, isPending, err := c.TransactionByHash(rctx, common.HexToHash(t.ID))
+	done(err)
+	if err == ethereum.NotFound {
+		return lr, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lr.Found = true
+	lr.Pending = isPending
+	return lr, nil
 }
 
-// New creates a new record of a transaction in the monitor system
-func (t *Transaction) New() error {
+// New creates a new record of a transaction in the monitor system. If the
+// transaction is already being watched on the same blockchain, the existing
+// record is loaded into t and ErrExists is returned so that retried
+// submissions do not fail. ErrConflict is returned if the hash is
+// already watched on another blockchain. A deleted transaction with the
+// same hash is replaced
+func (t *Transaction) New(ctx context.Context) error {
 	log.WithFields(log.Fields{
 		"action": "transaction.New",
 		"txid":   t.ID,
 	}).Print("Create new transaction")
+	et := &Transaction{}
+	res := DB.WithContext(ctx).Unscoped().Find(et, "id = ?", t.ID)
+	if res.Error != nil {
+		return backendError(res.Error)
+	}
+	deleted := res.RowsAffected > 0 && et.DeletedAt.Valid
+	if res.RowsAffected > 0 && !deleted {
+		if et.TenantID != t.TenantID {
+			return ErrTenantConflict
+		}
+		if et.Blockchain != t.Blockchain {
+			return ErrConflict
+		}
+		*t = *et
+		return ErrExists
+	}
 	t.Monitoring = true
-	tx := DB.Create(t)
-	if tx.Error != nil {
-		return tx.Error
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if deleted {
+			if err := purgeDeleted(tx, t.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(t).Error
+	})
+	if err != nil {
+		return backendError(err)
 	}
+	t.recordStateChange(ctx)
 	return nil
 }
 
+// purgeDeleted permanently deletes a soft-deleted transaction and its history,
+// so that its hash can be watched again
+func purgeDeleted(tx *gorm.DB, id string) error {
+	const deletedCond = "id = ? AND deleted_at IS NOT NULL"
+	deleted := tx.Unscoped().Model(&Transaction{}).Select("id").Where(deletedCond, id)
+	if err := tx.Where("transaction_id IN (?)", deleted).Delete(&TransactionEvent{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where(deletedCond, id).Delete(&Transaction{}).Error
+}
+
+// TenantScope limits a query to the transactions or addresses of a tenant. The
+// empty tenant only matches records created without a tenant
+func TenantScope(tenant string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("tenant_id = ?", tenant)
+	}
+}
+
+// tenantScope limits a query to the tenant of the transaction
+func (t *Transaction) tenantScope(db *gorm.DB) *gorm.DB {
+	return TenantScope(t.TenantID)(db)
+}
+
 // SetSuccess sets the success field on a transaction
-func (t *Transaction) SetSuccess() error {
+func (t *Transaction) SetSuccess(ctx context.Context) error {
 	log.WithFields(log.Fields{
 		"action": "transaction.SetSuccess",
 		"txid":   t.ID,
 	}).Printf("Set Success: %v", t.Success)
-	DB.Find(&Transaction{ID: t.ID}).Update("success", t.Success)
+	DB.WithContext(ctx).Find(&Transaction{ID: t.ID}).Update("success", t.Success)
 	return nil
 }
 
-// SetReviewed sets the reviewed field on a transaction
-func (t *Transaction) SetReviewed() error {
+// SetReviewed sets the reviewed field on a transaction, returning
+// ErrNotFound if the transaction does not exist
+func (t *Transaction) SetReviewed(ctx context.Context) error {
 	log.WithFields(log.Fields{
 		"action": "transaction.SetReviewed",
 		"txid":   t.ID,
 	}).Printf("Set reviewed: %v", t.Reviewed)
-	DB.Find(&Transaction{ID: t.ID}).Update("reviewed", t.Reviewed)
+	tx := DB.WithContext(ctx).Model(&Transaction{}).Scopes(t.tenantScope).Where("id = ?", t.ID).Update("reviewed", t.Reviewed)
+	if tx.Error != nil {
+		return backendError(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
+// MergeMetadata merges the provided metadata into the stored metadata of a
+// transaction, overwriting existing keys, and loads the result into t.Metadata
+func (t *Transaction) MergeMetadata(ctx context.Context, m MetadataMap) error {
+	log.WithFields(log.Fields{
+		"action": "transaction.MergeMetadata",
+		"txid":   t.ID,
+	}).Printf("keys=%d", len(m))
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		et := &Transaction{}
+		res := tx.Scopes(t.tenantScope).Find(et, "id = ?", t.ID)
+		if res.Error != nil {
+			return backendError(res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		if et.Metadata == nil {
+			et.Metadata = make(MetadataMap)
+		}
+		for k, v := range m {
+			et.Metadata[k] = v
+		}
+		if err := tx.Model(&Transaction{}).Where("id = ?", t.ID).Update("metadata", et.Metadata).Error; err != nil {
+			return backendError(err)
+		}
+		t.Metadata = et.Metadata
+		return nil
+	})
+}
+
+// StopMonitoring removes a transaction from the monitor without deleting it
+func (t *Transaction) StopMonitoring(ctx context.Context) error {
+	log.WithFields(log.Fields{
+		"action": "transaction.StopMonitoring",
+		"txid":   t.ID,
+	}).Print("Stop monitoring")
+	tx := DB.WithContext(ctx).Model(&Transaction{}).Scopes(t.tenantScope).Where("id = ?", t.ID).Updates(map[string]interface{}{
+		"monitoring": false,
+		"pending":    false,
+	})
+	if tx.Error != nil {
+		return backendError(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Replace records that a transaction was replaced by the transaction with the hash
+// newID, for example when it was resubmitted with a higher gas price. Monitoring of
+// the transaction is stopped and the replacement is watched for the same tenant on the
+// same blockchain with the same metadata and callback URL. The replacement is returned
+func (t *Transaction) Replace(ctx context.Context, newID string) (*Transaction, error) {
+	log.WithFields(log.Fields{
+		"action": "transaction.Replace",
+		"txid":   t.ID,
+	}).Printf("replacedBy=%s", newID)
+	nt := &Transaction{ID: newID}
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Scopes(t.tenantScope).Find(t, "id = ?", t.ID)
+		if res.Error != nil {
+			return backendError(res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		et := &Transaction{}
+		res = tx.Unscoped().Find(et, "id = ?", newID)
+		if res.Error != nil {
+			return backendError(res.Error)
+		}
+		if res.RowsAffected > 0 {
+			if !et.DeletedAt.Valid {
+				return ErrExists
+			}
+			if err := purgeDeleted(tx, newID); err != nil {
+				return backendError(err)
+			}
+		}
+		nt.TenantID = t.TenantID
+		nt.Blockchain = t.Blockchain
+		nt.Metadata = t.Metadata
+		nt.CallbackURL = t.CallbackURL
+		nt.DeadlineAt = t.DeadlineAt
+		nt.DeadlineBlock = t.DeadlineBlock
+		nt.Monitoring = true
+		if err := tx.Create(nt).Error; err != nil {
+			return backendError(err)
+		}
+		t.ReplacedBy = newID
+		t.Monitoring = false
+		t.Pending = false
+		return backendError(tx.Model(&Transaction{}).Where("id = ?", t.ID).Updates(map[string]interface{}{
+			"replaced_by": t.ReplacedBy,
+			"monitoring":  false,
+			"pending":     false,
+		}).Error)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return nt, nil
+}
+
+// Reverted reports whether a transaction was mined with a failed status, as opposed
+// to failing because it was dropped or could not be checked
+func (t *Transaction) Reverted() bool {
+	return !t.Monitoring && !t.Success && t.BlockNumber > 0 && strings.HasPrefix(t.Error, "failure")
+}
+
+// Requeue resumes monitoring of a transaction, clearing its error and resetting its
+// checks, for example after it failed because of a transient issue. ErrNotFailed is
+// returned if the transaction is still monitored or succeeded, and ErrReverted if
+// it reverted on-chain, unless force is set
+func (t *Transaction) Requeue(ctx context.Context, force bool) error {
+	log.WithFields(log.Fields{
+		"action": "transaction.Requeue",
+		"txid":   t.ID,
+	}).Printf("force=%v", force)
+	res := DB.WithContext(ctx).Scopes(t.tenantScope).Find(t, "id = ?", t.ID)
+	if res.Error != nil {
+		return backendError(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	if t.Monitoring || t.Success {
+		return ErrNotFailed
+	}
+	if t.Reverted() && !force {
+		return ErrReverted
+	}
+	t.Monitoring = true
+	t.Pending = false
+	t.Success = false
+	t.Dropped = false
+	t.Error = ""
+	t.Checks = 0
+	t.ConnectionErrors = 0
+	return t.Save(ctx)
+}
+
+// Delete soft-deletes a transaction so it is no longer monitored or returned
+func (t *Transaction) Delete(ctx context.Context) error {
+	log.WithFields(log.Fields{
+		"action": "transaction.Delete",
+		"txid":   t.ID,
+	}).Print("Delete transaction")
+	tx := DB.WithContext(ctx).Scopes(t.tenantScope).Delete(&Transaction{}, "id = ?", t.ID)
+	if tx.Error != nil {
+		return backendError(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ResumeConfiguredBlockchains resumes monitoring of transactions which were
+// stopped because their blockchain was not configured, if it now is
+func ResumeConfiguredBlockchains(ctx context.Context) error {
+	names := BlockchainNames()
+	if len(names) == 0 {
+		return nil
+	}
+	tx := DB.WithContext(ctx).Model(&Transaction{}).
+		Where("error = ? AND blockchain IN ?", ErrClientNotFound.Error(), names).
+		Updates(map[string]interface{}{
+			"monitoring": true,
+			"pending":    true,
+			"error":      "",
+		})
+	if tx.RowsAffected > 0 {
+		log.WithFields(log.Fields{
+			"action": "ResumeConfiguredBlockchains",
+		}).Printf("resumed=%d", tx.RowsAffected)
+	}
+	return tx.Error
+}
+
 // MonitoredTransactions retrieves all Monitored (and unreviewed)
-// transactions from the database
-func MonitoredTransactions() ([]Transaction, error) {
+// transactions which are due to be checked from the database
+func MonitoredTransactions(ctx context.Context) ([]Transaction, error) {
 	log.WithFields(log.Fields{
 		"action": "MonitoredTransactions",
 	}).Printf("get")
 	var txs []Transaction
-	DB.Find(
+	DB.WithContext(ctx).Where("next_check_at IS NULL OR next_check_at <= ?", time.Now()).Find(
 		&txs,
 		&Transaction{
 			Monitoring: true,
@@ -213,37 +1117,180 @@ func MonitoredTransactions() ([]Transaction, error) {
 	return txs, nil
 }
 
-// monitorWorker concurrently checks transactions as they are received
-func monitorWorker(ctx context.Context, tin <-chan *Transaction, tout chan<- *Transaction) {
-	for t := range tin {
-		t.CheckSuccess(ctx)
-		tout <- t
+// MonitoredTransactionsAfter retrieves up to limit Monitored (and unreviewed)
+// transactions which were due to be checked at now, ordered by ID and starting
+// after afterID. Paging by ID rather than by offset ensures transactions are not
+// skipped as checked transactions leave the result set
+func MonitoredTransactionsAfter(ctx context.Context, now time.Time, afterID string, limit int) ([]Transaction, error) {
+	var txs []Transaction
+	tx := DB.WithContext(ctx).
+		Where("monitoring = ? AND reviewed = ?", true, false).
+		Where("next_check_at IS NULL OR next_check_at <= ?", now).
+		Where("id > ?", afterID).
+		Order("id").
+		Limit(limit).
+		Find(&txs)
+	return txs, tx.Error
+}
+
+// MonitorPageSize returns the number of monitored transactions loaded from the
+// database at a time, configured with MONITOR_PAGE_SIZE and defaulting to 500
+func MonitorPageSize() int {
+	ps, perr := strconv.Atoi(os.Getenv("MONITOR_PAGE_SIZE"))
+	if perr != nil || ps <= 0 {
+		return 500
+	}
+	return ps
+}
+
+// MonitorWorkers returns the number of concurrent monitor worker goroutines
+// configured with MONITOR_WORKERS, defaulting to 10
+func MonitorWorkers() int {
+	return monitorWorkersEnv("MONITOR_WORKERS", 10)
+}
+
+// ChecksTimer returns the default interval between checks, configured
+// in seconds with CHECKS_TIMER and defaulting to 60 seconds
+func ChecksTimer() time.Duration {
+	ct, cerr := strconv.Atoi(os.Getenv("CHECKS_TIMER"))
+	if cerr != nil || ct <= 0 {
+		return time.Second * 60
+	}
+	return time.Second * time.Duration(ct)
+}
+
+// CheckInterval returns the interval between checks of a blockchain,
+// configured in seconds with CHECK_INTERVAL_<name> and falling back to ChecksTimer
+func CheckInterval(name string) time.Duration {
+	ci, cerr := strconv.Atoi(os.Getenv("CHECK_INTERVAL_" + name))
+	if cerr != nil || ci <= 0 {
+		return ChecksTimer()
+	}
+	return time.Second * time.Duration(ci)
+}
+
+// MinCheckInterval returns the shortest check interval of the configured
+// blockchains, which is how often the monitor needs to run
+func MinCheckInterval() time.Duration {
+	min := ChecksTimer()
+	for _, name := range BlockchainNames() {
+		if ci := CheckInterval(name); ci < min {
+			min = ci
+		}
+	}
+	return min
+}
+
+// ChecksJitter returns the percentage of the check interval added at random to
+// each wait between runs of the monitor, configured with CHECKS_JITTER. Zero,
+// the default, waits exactly the check interval
+func ChecksJitter() int {
+	cj, cerr := strconv.Atoi(os.Getenv("CHECKS_JITTER"))
+	if cerr != nil || cj < 0 {
+		return 0
+	}
+	if cj > 100 {
+		return 100
+	}
+	return cj
+}
+
+var (
+	// jitterRand is seeded per process so that replicas started together
+	// do not wait the same jittered intervals
+	jitterRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterRandMu sync.Mutex
+)
+
+// Jitter returns d extended by a random duration of up to ChecksJitter
+// percent of d. The jitter only ever lengthens d, so that blockchains are
+// always due to be checked when the monitor runs
+func Jitter(d time.Duration) time.Duration {
+	band := int64(d) * int64(ChecksJitter()) / 100
+	if band <= 0 {
+		return d
+	}
+	jitterRandMu.Lock()
+	defer jitterRandMu.Unlock()
+	return d + time.Duration(jitterRand.Int63n(band+1))
+}
+
+var (
+	// lastChecked is when the transactions of each blockchain were last checked
+	lastChecked   = make(map[string]time.Time)
+	lastCheckedMu sync.Mutex
+)
+
+// dueChecker returns a function reporting whether the check interval of a
+// blockchain has elapsed at now. Each blockchain is marked as checked the first
+// time it is found to be due, so the result is stable for the rest of the run.
+// Blockchains with a newHeads subscription are due when a block has arrived
+func dueChecker(now time.Time) func(name string) bool {
+	decided := make(map[string]bool)
+	return func(name string) bool {
+		if due, ok := decided[name]; ok {
+			return due
+		}
+		if due, ok := headDue(name); ok {
+			decided[name] = due
+			return due
+		}
+		lastCheckedMu.Lock()
+		defer lastCheckedMu.Unlock()
+		// allow for the monitor running slightly early
+		due := now.Sub(lastChecked[name]) >= CheckInterval(name)-time.Second
+		if due {
+			lastChecked[name] = now
+		}
+		decided[name] = due
+		return due
 	}
 }
 
-// CheckMonitoredTransactions loops through all Monitored Transactions
-// and checks their current status on the blockchain
+// CheckMonitoredTransactions loops through all Monitored Transactions whose
+// blockchain is due to be checked and checks their current status on the blockchain.
+// Transactions are loaded in pages of MonitorPageSize and passed to the workers
+// through a bounded channel, so memory use does not grow with the backlog. The
+// number of workers scales between MonitorWorkersMin and MonitorWorkersMax with
+// the backlog
 func CheckMonitoredTransactions(ctx context.Context) error {
 	log.WithFields(log.Fields{
 		"action": "CheckMonitoredTransactions",
 	}).Printf("run")
-	txs, err := MonitoredTransactions()
-	if err != nil {
-		return err
-	}
-	tin := make(chan *Transaction, len(txs))
-	tout := make(chan *Transaction, len(txs))
-	for w := 0; w < 10; w++ {
-		go monitorWorker(ctx, tin, tout)
-	}
-	for _, t := range txs {
-		tin <- &t
+	now := time.Now()
+	if err := ExpireDeadlines(ctx, now); err != nil {
+		log.WithFields(log.Fields{
+			"action": "CheckMonitoredTransactions",
+		}).Printf("error %v", err)
 	}
-	close(tin)
-	for i := 0; i < len(txs); i++ {
-		<-tout
+	isDue := dueChecker(now)
+	pageSize := MonitorPageSize()
+	pool := newWorkerPool(ctx, MonitorWorkersMin(), MonitorWorkersMax())
+	var afterID string
+	var err error
+	for {
+		var txs []Transaction
+		txs, err = MonitoredTransactionsAfter(ctx, now, afterID, pageSize)
+		if err != nil || len(txs) == 0 {
+			break
+		}
+		afterID = txs[len(txs)-1].ID
+		// send pointers into the slice rather than to the loop variable, which
+		// is shared between iterations
+		var due []*Transaction
+		for i := range txs {
+			if isDue(txs[i].Blockchain) {
+				due = append(due, &txs[i])
+			}
+		}
+		BatchReceipts(ctx, due)
+		pool.submit(due)
+		if len(txs) < pageSize {
+			break
+		}
 	}
-	return nil
+	pool.close()
+	return err
 }
 
 func Ping(db *gorm.DB) error {
@@ -261,12 +1308,66 @@ func Healthcheck() error {
 	return nil
 }
 
+// HealthcheckMaxFailures returns the number of consecutive failed health checks
+// after which the process exits, configured with HEALTHCHECK_MAX_FAILURES
+// and defaulting to 6
+func HealthcheckMaxFailures() int {
+	mf, merr := strconv.Atoi(os.Getenv("HEALTHCHECK_MAX_FAILURES"))
+	if merr != nil || mf <= 0 {
+		return 6
+	}
+	return mf
+}
+
+// HealthcheckInterval returns the interval between database health checks,
+// configured with HEALTHCHECK_INTERVAL as a duration such as "30s" or a number
+// of seconds, and defaulting to 10 seconds
+func HealthcheckInterval() time.Duration {
+	hi := os.Getenv("HEALTHCHECK_INTERVAL")
+	if hi == "" {
+		return time.Second * 10
+	}
+	d, derr := time.ParseDuration(hi)
+	if derr != nil {
+		if s, serr := strconv.Atoi(hi); serr == nil {
+			d, derr = time.Second*time.Duration(s), nil
+		}
+	}
+	if derr != nil || d <= 0 {
+		log.WithFields(log.Fields{
+			"action": "HealthcheckInterval",
+		}).Warnf("invalid HEALTHCHECK_INTERVAL %q, defaulting to 10s", hi)
+		return time.Second * 10
+	}
+	return d
+}
+
+// Healthchecker periodically pings the database. Failures are logged, and the
+// process only exits after HealthcheckMaxFailures consecutive failures so that
+// a transient database error does not take down the monitor
 func Healthchecker() error {
+	err := healthcheckLoop(Healthcheck, HealthcheckMaxFailures(), HealthcheckInterval())
+	log.Fatalf("database unhealthy: %v", err)
+	return err
+}
+
+// healthcheckLoop runs check every interval until it fails maxFailures times
+// in a row, and returns the last error
+func healthcheckLoop(check func() error, maxFailures int, interval time.Duration) error {
+	var failures int
 	for {
-		if err := Healthcheck(); err != nil {
-			log.Fatal(err)
-			return err
+		if err := check(); err != nil {
+			failures++
+			log.WithFields(log.Fields{
+				"action":   "Healthchecker",
+				"failures": failures,
+			}).Errorf("error %v", err)
+			if failures >= maxFailures {
+				return fmt.Errorf("%d consecutive failed checks: %w", failures, err)
+			}
+		} else {
+			failures = 0
 		}
-		time.Sleep(time.Second * 10)
+		time.Sleep(interval)
 	}
 }
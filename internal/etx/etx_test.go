@@ -0,0 +1,1026 @@
+package etx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB points DB and ReadDB at a new sqlite database for the test
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevReadDB := DB, ReadDB
+	DB, ReadDB = db, db
+	t.Cleanup(func() {
+		DB, ReadDB = prevDB, prevReadDB
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// testTxID returns a valid transaction hash of the repeated hex digit c
+func testTxID(c string) string {
+	return "0x" + strings.Repeat(c, 64)
+}
+
+// setChecksThreshold configures the CHECKS_THRESHOLD for the test, and restores
+// the configured threshold afterwards
+func setChecksThreshold(t *testing.T, threshold string) {
+	t.Helper()
+	// registered before t.Setenv so it runs after the env var is restored
+	t.Cleanup(ConfigureChecksThresholds)
+	t.Setenv("CHECKS_THRESHOLD", threshold)
+	ConfigureChecksThresholds()
+}
+
+// newTestTransaction stores a monitored transaction on the eth blockchain
+func newTestTransaction(t *testing.T, id, tenant string) *Transaction {
+	t.Helper()
+	tx := &Transaction{ID: id, Blockchain: "eth", TenantID: tenant}
+	if err := tx.New(context.Background()); err != nil {
+		t.Fatalf("New(%s): %v", id, err)
+	}
+	return tx
+}
+
+func TestNewAfterDelete(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	id := testTxID("a")
+	tx := newTestTransaction(t, id, "")
+	tx.Success = true
+	tx.Monitoring = false
+	if err := tx.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Transaction{ID: id}).Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	nt := newTestTransaction(t, id, "")
+	if !nt.Monitoring || nt.Success {
+		t.Errorf("re-added transaction monitoring=%v success=%v, want a new monitored transaction", nt.Monitoring, nt.Success)
+	}
+	var count int64
+	DB.Unscoped().Model(&Transaction{}).Where("id = ?", id).Count(&count)
+	if count != 1 {
+		t.Errorf("got %d rows for %s, want 1", count, id)
+	}
+	events, err := nt.History(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].NewState != "monitoring" {
+		t.Errorf("got history %+v, want only the new transaction's history", events)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	setupTestDB(t)
+	setupTestRPC(t, "poly", func(method string, params []json.RawMessage) (interface{}, int) {
+		return nil, 0
+	})
+	ctx := context.Background()
+	deadline := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	block := uint64(100)
+	tests := []struct {
+		name       string
+		old        Transaction
+		replacedBy string
+	}{
+		{
+			"metadata",
+			Transaction{ID: testTxID("1"), Blockchain: "eth", Metadata: MetadataMap{"order": "42"}, CallbackURL: "https://example.com/hook"},
+			testTxID("2"),
+		},
+		{
+			"tenant and blockchain",
+			Transaction{ID: testTxID("3"), Blockchain: "poly", TenantID: "a", Metadata: MetadataMap{"k": "v"}},
+			testTxID("4"),
+		},
+		{
+			"deadlines",
+			Transaction{ID: testTxID("5"), Blockchain: "eth", DeadlineAt: &deadline, DeadlineBlock: &block},
+			testTxID("6"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := tt.old
+			if err := old.New(ctx); err != nil {
+				t.Fatal(err)
+			}
+			nt, err := (&Transaction{ID: old.ID, TenantID: old.TenantID}).Replace(ctx, tt.replacedBy)
+			if err != nil {
+				t.Fatalf("Replace: %v", err)
+			}
+			st := &Transaction{}
+			if err := DB.First(st, "id = ?", tt.replacedBy).Error; err != nil {
+				t.Fatalf("finding the replacement: %v", err)
+			}
+			for _, got := range []*Transaction{nt, st} {
+				if !got.Monitoring || got.TenantID != old.TenantID || got.Blockchain != old.Blockchain || got.CallbackURL != old.CallbackURL {
+					t.Errorf("got replacement monitoring=%v tenant=%q blockchain=%q callbackUrl=%q, want those of %+v",
+						got.Monitoring, got.TenantID, got.Blockchain, got.CallbackURL, old)
+				}
+				if !reflect.DeepEqual(got.Metadata, old.Metadata) {
+					t.Errorf("got replacement metadata %v, want %v", got.Metadata, old.Metadata)
+				}
+				if (got.DeadlineAt == nil) != (old.DeadlineAt == nil) || got.DeadlineAt != nil && !got.DeadlineAt.Equal(*old.DeadlineAt) {
+					t.Errorf("got replacement deadlineAt %v, want %v", got.DeadlineAt, old.DeadlineAt)
+				}
+				if !reflect.DeepEqual(got.DeadlineBlock, old.DeadlineBlock) {
+					t.Errorf("got replacement deadlineBlock %v, want %v", got.DeadlineBlock, old.DeadlineBlock)
+				}
+			}
+			ot := &Transaction{}
+			if err := DB.First(ot, "id = ?", old.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if ot.ReplacedBy != tt.replacedBy || ot.Monitoring || ot.Pending {
+				t.Errorf("got replaced transaction replacedBy=%q monitoring=%v pending=%v, want replacedBy=%q and not monitored",
+					ot.ReplacedBy, ot.Monitoring, ot.Pending, tt.replacedBy)
+			}
+		})
+	}
+	if _, err := (&Transaction{ID: testTxID("3"), TenantID: "b"}).Replace(ctx, testTxID("7")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("replacing another tenant's transaction returned %v, want ErrNotFound", err)
+	}
+	if _, err := (&Transaction{ID: testTxID("8")}).Replace(ctx, testTxID("9")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("replacing an unknown transaction returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestReplaceWithDeleted(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	oldID, newID := testTxID("a"), testTxID("b")
+	newTestTransaction(t, oldID, "")
+	newTestTransaction(t, newID, "")
+	if err := (&Transaction{ID: newID}).Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	nt, err := (&Transaction{ID: oldID}).Replace(ctx, newID)
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if nt.ID != newID || !nt.Monitoring {
+		t.Errorf("got replacement %s monitoring=%v, want %s monitoring", nt.ID, nt.Monitoring, newID)
+	}
+	if _, err := (&Transaction{ID: oldID}).Replace(ctx, newID); !errors.Is(err, ErrExists) {
+		t.Errorf("replacing with a watched hash returned %v, want ErrExists", err)
+	}
+}
+
+// rpcError is a JSON-RPC error response of a testRPC handler
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data,omitempty"`
+}
+
+// rpcHandler answers a JSON-RPC call to a testRPC. Returning a non-zero status
+// fails the whole HTTP request with that status, and returning an *rpcError
+// sends it as the error of the call
+type rpcHandler func(method string, params []json.RawMessage) (result interface{}, status int)
+
+// rpcRequest is a single JSON-RPC call
+type rpcRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// setupTestRPC starts a fake JSON-RPC endpoint answered by handle and configures
+// it as the only client of the blockchain name. eth_chainId returns 1 unless
+// handle answers it
+func setupTestRPC(t *testing.T, name string, handle rpcHandler) *httptest.Server {
+	t.Helper()
+	reply := func(req rpcRequest) (map[string]interface{}, int) {
+		res, status := handle(req.Method, req.Params)
+		if res == nil && status == 0 && req.Method == "eth_chainId" {
+			res = "0x1"
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if re, ok := res.(*rpcError); ok {
+			resp["error"] = re
+		} else {
+			resp["result"] = res
+		}
+		return resp, status
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var out interface{}
+		if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+			var reqs []rpcRequest
+			json.Unmarshal(body, &reqs)
+			resps := []interface{}{}
+			for _, req := range reqs {
+				resp, status := reply(req)
+				if status != 0 {
+					http.Error(w, http.StatusText(status), status)
+					return
+				}
+				resps = append(resps, resp)
+			}
+			out = resps
+		} else {
+			var req rpcRequest
+			json.Unmarshal(body, &req)
+			resp, status := reply(req)
+			if status != 0 {
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			out = resp
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}))
+	rc, err := rpc.DialHTTP(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, hadPrev := Clients[name]
+	delete(Clients, name)
+	AddBlockchainClient(name, rc)
+	t.Cleanup(func() {
+		if hadPrev {
+			Clients[name] = prev
+		} else {
+			delete(Clients, name)
+		}
+		clientIndexMu.Lock()
+		delete(clientIndex, name)
+		clientIndexMu.Unlock()
+		rc.Close()
+		srv.Close()
+	})
+	return srv
+}
+
+// testSignedTx returns a signed legacy transaction and its JSON-RPC representation
+// as mined in block 16, or as pending if pending is true
+func testSignedTx(t *testing.T, nonce uint64, pending bool) (*types.Transaction, map[string]interface{}) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	tx, err := types.SignTx(types.NewTransaction(nonce, to, big.NewInt(1), 21000, big.NewInt(1e9), nil), types.NewEIP155Signer(big.NewInt(1)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jd, err := tx.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]interface{}{}
+	json.Unmarshal(jd, &fields)
+	fields["from"] = crypto.PubkeyToAddress(key.PublicKey).Hex()
+	if !pending {
+		fields["blockHash"] = common.HexToHash("0xbb").Hex()
+		fields["blockNumber"] = "0x10"
+		fields["transactionIndex"] = "0x0"
+	}
+	return tx, fields
+}
+
+// testReceipt returns the receipt of tx mined in block 16 with the provided status
+func testReceipt(tx *types.Transaction, status uint64) *types.Receipt {
+	return &types.Receipt{
+		Status:            status,
+		CumulativeGasUsed: 21000,
+		GasUsed:           21000,
+		TxHash:            tx.Hash(),
+		BlockHash:         common.HexToHash("0xbb"),
+		BlockNumber:       big.NewInt(16),
+		Logs:              []*types.Log{},
+	}
+}
+
+func TestCheckSuccessConnectionErrors(t *testing.T) {
+	t.Setenv("RPC_RETRIES", "0")
+	setChecksThreshold(t, "1")
+	tx, txJSON := testSignedTx(t, 0, false)
+	tests := []struct {
+		name             string
+		handle           rpcHandler
+		closed           bool
+		monitoring       bool
+		checks           int
+		connectionErrors int
+	}{
+		{
+			name: "http error",
+			handle: func(method string, params []json.RawMessage) (interface{}, int) {
+				if method == "eth_getTransactionByHash" {
+					return nil, http.StatusBadGateway
+				}
+				return nil, 0
+			},
+			monitoring:       true,
+			checks:           0,
+			connectionErrors: 1,
+		},
+		{
+			name: "no healthy client",
+			handle: func(method string, params []json.RawMessage) (interface{}, int) {
+				return nil, http.StatusServiceUnavailable
+			},
+			monitoring:       true,
+			checks:           0,
+			connectionErrors: 1,
+		},
+		{
+			name:             "connection refused",
+			closed:           true,
+			monitoring:       true,
+			checks:           0,
+			connectionErrors: 1,
+		},
+		{
+			name: "transaction error",
+			handle: func(method string, params []json.RawMessage) (interface{}, int) {
+				if method == "eth_getTransactionByHash" {
+					return &rpcError{Code: -32602, Message: "invalid argument"}, 0
+				}
+				return nil, 0
+			},
+			monitoring:       false,
+			checks:           1,
+			connectionErrors: 0,
+		},
+		{
+			name: "block number error",
+			handle: func(method string, params []json.RawMessage) (interface{}, int) {
+				switch method {
+				case "eth_getTransactionByHash":
+					return txJSON, 0
+				case "eth_getTransactionReceipt":
+					return testReceipt(tx, 1), 0
+				case "eth_blockNumber":
+					return &rpcError{Code: -32000, Message: "header not found"}, 0
+				}
+				return nil, 0
+			},
+			monitoring:       true,
+			checks:           1,
+			connectionErrors: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			srv := setupTestRPC(t, "eth", tt.handle)
+			if tt.closed {
+				srv.Close()
+			}
+			ct := newTestTransaction(t, tx.Hash().Hex(), "")
+			ct.CheckSuccess(context.Background())
+			st := &Transaction{}
+			DB.Find(st, "id = ?", ct.ID)
+			if st.Monitoring != tt.monitoring || st.Checks != tt.checks || st.ConnectionErrors != tt.connectionErrors {
+				t.Errorf("got monitoring=%v checks=%d connectionErrors=%d error=%q, want monitoring=%v checks=%d connectionErrors=%d",
+					st.Monitoring, st.Checks, st.ConnectionErrors, st.Error, tt.monitoring, tt.checks, tt.connectionErrors)
+			}
+		})
+	}
+}
+
+func TestCheckSuccessThresholdAfterErrors(t *testing.T) {
+	t.Setenv("RPC_RETRIES", "0")
+	setChecksThreshold(t, "2")
+	setupTestDB(t)
+	tx, txJSON := testSignedTx(t, 0, false)
+	setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+		switch method {
+		case "eth_getTransactionByHash":
+			return txJSON, 0
+		case "eth_getTransactionReceipt":
+			return testReceipt(tx, 1), 0
+		case "eth_blockNumber":
+			return &rpcError{Code: -32000, Message: "header not found"}, 0
+		}
+		return nil, 0
+	})
+	ct := newTestTransaction(t, tx.Hash().Hex(), "")
+	for i := 0; i < 3; i++ {
+		ct.CheckSuccess(context.Background())
+	}
+	if ct.Monitoring || ct.Error != "exceeded checks threshold" {
+		t.Errorf("got monitoring=%v error=%q after 3 failed checks, want the checks threshold exceeded", ct.Monitoring, ct.Error)
+	}
+}
+
+func TestRevertReason(t *testing.T) {
+	tx, txJSON := testSignedTx(t, 0, false)
+	// Error(string) with the reason "nope"
+	revert := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"6e6f706500000000000000000000000000000000000000000000000000000000"
+	tests := []struct {
+		name   string
+		call   interface{}
+		reason string
+	}{
+		{"error string", &rpcError{Code: 3, Message: "execution reverted", Data: revert}, "failure: nope"},
+		{"no reason", &rpcError{Code: 3, Message: "execution reverted"}, "failure"},
+		{"no revert", "0x", "failure"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			var callBlock string
+			setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+				switch method {
+				case "eth_getTransactionByHash":
+					return txJSON, 0
+				case "eth_getTransactionReceipt":
+					return testReceipt(tx, 0), 0
+				case "eth_blockNumber":
+					return "0x20", 0
+				case "eth_call":
+					json.Unmarshal(params[1], &callBlock)
+					return tt.call, 0
+				}
+				return nil, 0
+			})
+			ct := newTestTransaction(t, tx.Hash().Hex(), "")
+			ct.CheckSuccess(context.Background())
+			if ct.Success || ct.Error != tt.reason {
+				t.Errorf("got success=%v error=%q, want error %q", ct.Success, ct.Error, tt.reason)
+			}
+			if callBlock != "0xf" {
+				t.Errorf("simulated at block %s, want the parent block 0xf", callBlock)
+			}
+		})
+	}
+}
+
+func TestExpireDeadlines(t *testing.T) {
+	t.Setenv("MONITOR_PAGE_SIZE", "2")
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	block := func(n uint64) *uint64 { return &n }
+	tests := []struct {
+		name          string
+		deadlineAt    *time.Time
+		deadlineBlock *uint64
+		monitoring    bool
+		expired       bool
+	}{
+		{"no deadline", nil, nil, true, false},
+		{"past deadline", &past, nil, true, true},
+		{"future deadline", &future, nil, true, false},
+		{"resolved past deadline", &past, nil, false, false},
+		{"reached block", nil, block(32), true, true},
+		{"passed block", nil, block(16), true, true},
+		{"future block", nil, block(33), true, false},
+		{"resolved reached block", nil, block(16), false, false},
+	}
+	setupTestDB(t)
+	setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+		if method == "eth_blockNumber" {
+			return "0x20", 0
+		}
+		return nil, 0
+	})
+	ids := make([]string, len(tests))
+	for i, tt := range tests {
+		ids[i] = testTxID(strconv.Itoa(i))
+		tx := &Transaction{
+			ID:            ids[i],
+			Blockchain:    "eth",
+			Monitoring:    tt.monitoring,
+			DeadlineAt:    tt.deadlineAt,
+			DeadlineBlock: tt.deadlineBlock,
+		}
+		if err := DB.Create(tx).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ExpireDeadlines(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &Transaction{}
+			DB.Find(st, "id = ?", ids[i])
+			if expired := st.Error == "deadline exceeded"; expired != tt.expired {
+				t.Errorf("got error %q, want expired=%v", st.Error, tt.expired)
+			}
+			if tt.expired && st.Monitoring {
+				t.Errorf("expired transaction is still monitored")
+			}
+		})
+	}
+}
+
+func TestExpireDeadlinesUnhealthyBlockchain(t *testing.T) {
+	setupTestDB(t)
+	setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+		return nil, http.StatusServiceUnavailable
+	})
+	deadline := uint64(1)
+	tx := &Transaction{ID: testTxID("a"), Blockchain: "eth", Monitoring: true, DeadlineBlock: &deadline}
+	if err := DB.Create(tx).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ExpireDeadlines(context.Background(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	st := &Transaction{}
+	DB.Find(st, "id = ?", tx.ID)
+	if !st.Monitoring || st.Error != "" {
+		t.Errorf("got monitoring=%v error=%q, want the block deadline kept until the head is known", st.Monitoring, st.Error)
+	}
+}
+
+func TestChecksTimer(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", time.Minute},
+		{"15", 15 * time.Second},
+		{"0", time.Minute},
+		{"-5", time.Minute},
+		{"soon", time.Minute},
+	}
+	for _, tt := range tests {
+		t.Setenv("CHECKS_TIMER", tt.value)
+		if got := ChecksTimer(); got != tt.want {
+			t.Errorf("ChecksTimer() with CHECKS_TIMER=%q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// resetLastChecked forgets when the blockchains were last checked,
+// restoring the previous times when the test ends
+func resetLastChecked(t *testing.T, names ...string) {
+	lastCheckedMu.Lock()
+	defer lastCheckedMu.Unlock()
+	for _, name := range names {
+		prev, ok := lastChecked[name]
+		delete(lastChecked, name)
+		name := name
+		t.Cleanup(func() {
+			lastCheckedMu.Lock()
+			defer lastCheckedMu.Unlock()
+			if ok {
+				lastChecked[name] = prev
+			} else {
+				delete(lastChecked, name)
+			}
+		})
+	}
+}
+
+func TestDueChecker(t *testing.T) {
+	t.Setenv("CHECK_INTERVAL_eth", "10")
+	t.Setenv("CHECK_INTERVAL_poly", "30")
+	resetLastChecked(t, "eth", "poly")
+	start := time.Now()
+	tests := []struct {
+		after time.Duration
+		eth   bool
+		poly  bool
+	}{
+		{0, true, true},
+		{5 * time.Second, false, false},
+		{10 * time.Second, true, false},
+		{20 * time.Second, true, false},
+		{25 * time.Second, false, false},
+		{30 * time.Second, true, true},
+		{40 * time.Second, true, false},
+		{60 * time.Second, true, true},
+	}
+	for _, tt := range tests {
+		isDue := dueChecker(start.Add(tt.after))
+		eth, poly := isDue("eth"), isDue("poly")
+		if eth != tt.eth || poly != tt.poly {
+			t.Errorf("after %v got eth due %v and poly due %v, want %v and %v", tt.after, eth, poly, tt.eth, tt.poly)
+		}
+		// the result is stable for the rest of the run
+		if isDue("eth") != eth || isDue("poly") != poly {
+			t.Errorf("after %v the due blockchains changed within a run", tt.after)
+		}
+	}
+}
+
+func TestCheckMonitoredTransactionsIntervals(t *testing.T) {
+	t.Setenv("CHECK_INTERVAL_eth", "10")
+	t.Setenv("CHECK_INTERVAL_poly", "30")
+	setupTestDB(t)
+	resetLastChecked(t, "eth", "poly")
+	var mu sync.Mutex
+	checked := make(map[string]int)
+	count := func(name string) rpcHandler {
+		return func(method string, params []json.RawMessage) (interface{}, int) {
+			if method == "eth_getTransactionByHash" {
+				mu.Lock()
+				checked[name]++
+				mu.Unlock()
+			}
+			return nil, 0
+		}
+	}
+	setupTestRPC(t, "eth", count("eth"))
+	setupTestRPC(t, "poly", count("poly"))
+	for i, c := range []string{"1", "2", "3", "4"} {
+		tx := &Transaction{ID: testTxID(c), Blockchain: []string{"eth", "poly"}[i%2]}
+		if err := tx.New(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name            string
+		ethAgo, polyAgo time.Duration
+		eth, poly       int
+	}{
+		{"both due", time.Minute, time.Minute, 2, 2},
+		{"eth due", 15 * time.Second, 15 * time.Second, 2, 0},
+		{"poly due", 5 * time.Second, 45 * time.Second, 0, 2},
+		{"neither due", 5 * time.Second, 15 * time.Second, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// transactions are due again immediately after a check
+			DB.Model(&Transaction{}).Where("1 = 1").Update("next_check_at", time.Time{})
+			now := time.Now()
+			lastCheckedMu.Lock()
+			lastChecked["eth"] = now.Add(-tt.ethAgo)
+			lastChecked["poly"] = now.Add(-tt.polyAgo)
+			lastCheckedMu.Unlock()
+			mu.Lock()
+			checked = make(map[string]int)
+			mu.Unlock()
+			if err := CheckMonitoredTransactions(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if checked["eth"] != tt.eth || checked["poly"] != tt.poly {
+				t.Errorf("checked %d eth and %d poly transactions, want %d and %d", checked["eth"], checked["poly"], tt.eth, tt.poly)
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	tests := []struct {
+		jitter string
+		max    time.Duration
+	}{
+		{"", time.Minute},
+		{"0", time.Minute},
+		{"-10", time.Minute},
+		{"20", 72 * time.Second},
+		{"100", 2 * time.Minute},
+		{"250", 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run("CHECKS_JITTER="+tt.jitter, func(t *testing.T) {
+			t.Setenv("CHECKS_JITTER", tt.jitter)
+			for i := 0; i < 100; i++ {
+				if d := Jitter(time.Minute); d < time.Minute || d > tt.max {
+					t.Fatalf("Jitter(1m) = %v, want between 1m and %v", d, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestDuplicateTransactions(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		blockchain string
+		tenant     string
+		err        error
+	}{
+		{"same blockchain", "eth", "", ErrExists},
+		{"other blockchain", "poly", "", ErrConflict},
+		{"other tenant", "eth", "b", ErrTenantConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			id := testTxID("a")
+			newTestTransaction(t, id, "")
+			dup := &Transaction{ID: id, Blockchain: tt.blockchain, TenantID: tt.tenant}
+			if err := dup.New(ctx); !errors.Is(err, tt.err) {
+				t.Errorf("New returned %v, want %v", err, tt.err)
+			}
+			// the primary key rejects duplicates which bypass New
+			if err := DB.Create(&Transaction{ID: id, Blockchain: tt.blockchain}).Error; err == nil {
+				t.Errorf("inserting a duplicate hash succeeded")
+			}
+			var n int64
+			DB.Model(&Transaction{}).Where("id = ?", id).Count(&n)
+			if n != 1 {
+				t.Errorf("got %d rows for the hash, want 1", n)
+			}
+		})
+	}
+}
+
+func TestDeleteExcludesMonitored(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	kept := newTestTransaction(t, testTxID("a"), "")
+	deleted := newTestTransaction(t, testTxID("b"), "")
+	if err := deleted.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleted.Delete(ctx); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting twice returned %v, want ErrNotFound", err)
+	}
+	monitored, err := MonitoredTransactions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := MonitoredTransactionsAfter(ctx, time.Now(), "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, txs := range map[string][]Transaction{"MonitoredTransactions": monitored, "MonitoredTransactionsAfter": page} {
+		if len(txs) != 1 || txs[0].ID != kept.ID {
+			t.Errorf("%s returned %d transactions, want only %s", name, len(txs), kept.ID)
+		}
+	}
+}
+
+func TestCheckSuccessConfirmations(t *testing.T) {
+	t.Setenv("CONFIRMATIONS_REQUIRED", "3")
+	setupTestDB(t)
+	tx, txJSON := testSignedTx(t, 0, false)
+	head := uint64(16)
+	setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+		switch method {
+		case "eth_getTransactionByHash":
+			return txJSON, 0
+		case "eth_getTransactionReceipt":
+			return testReceipt(tx, 1), 0
+		case "eth_blockNumber":
+			return fmt.Sprintf("0x%x", head), 0
+		}
+		return nil, 0
+	})
+	ct := newTestTransaction(t, tx.Hash().Hex(), "")
+	for confirmations := 0; confirmations <= 3; confirmations++ {
+		ct.CheckSuccess(context.Background())
+		resolved := confirmations >= 3
+		if ct.Confirmations != confirmations || ct.Success != resolved || ct.Monitoring == resolved {
+			t.Errorf("at head %d got confirmations=%d success=%v monitoring=%v, want confirmations=%d success=%v",
+				head, ct.Confirmations, ct.Success, ct.Monitoring, confirmations, resolved)
+		}
+		head++
+	}
+}
+
+func TestCheckSuccessTimeout(t *testing.T) {
+	t.Setenv("RPC_TIMEOUT", "1")
+	t.Setenv("RPC_RETRIES", "0")
+	setupTestDB(t)
+	setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+		if method == "eth_getTransactionByHash" {
+			time.Sleep(time.Millisecond * 1200)
+		}
+		return nil, 0
+	})
+	ct := newTestTransaction(t, testTxID("a"), "")
+	start := time.Now()
+	ct.CheckSuccess(context.Background())
+	if d := time.Since(start); d > time.Millisecond*1150 {
+		t.Errorf("check took %v, want it to time out after 1s", d)
+	}
+	if !ct.Monitoring || !ct.Pending || ct.Error != "" {
+		t.Errorf("got monitoring=%v pending=%v error=%q, want the timed out transaction kept pending", ct.Monitoring, ct.Pending, ct.Error)
+	}
+}
+
+func TestCheckSuccessDropped(t *testing.T) {
+	t.Setenv("DROPPED_GRACE_CHECKS", "2")
+	tx, txJSON := testSignedTx(t, 0, true)
+	tests := []struct {
+		name        string
+		gracePeriod string
+		foundAt     int
+		dropped     []bool
+	}{
+		{"dropped after the grace checks", "", 0, []bool{false, false, true}},
+		{"propagated during the grace checks", "", 2, []bool{false, false, false}},
+		{"within the grace period", "1h", 0, []bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DROPPED_GRACE_PERIOD", tt.gracePeriod)
+			setupTestDB(t)
+			calls := 0
+			setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+				if method == "eth_getTransactionByHash" {
+					calls++
+					if tt.foundAt > 0 && calls >= tt.foundAt {
+						return txJSON, 0
+					}
+				}
+				return nil, 0
+			})
+			ct := newTestTransaction(t, tx.Hash().Hex(), "")
+			for i, dropped := range tt.dropped {
+				ct.CheckSuccess(context.Background())
+				if ct.Dropped != dropped || ct.Monitoring == dropped || (ct.Error == "dropped") != dropped {
+					t.Errorf("check %d got dropped=%v monitoring=%v error=%q, want dropped=%v",
+						i+1, ct.Dropped, ct.Monitoring, ct.Error, dropped)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthcheckLoop(t *testing.T) {
+	errPing := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		results string
+		checks  int
+	}{
+		{"consecutive failures", "fff", 3},
+		{"intermittent failures", "ffoffofff", 9},
+		{"recovered", "fofofofofofff", 13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := 0
+			check := func() error {
+				checks++
+				if checks > len(tt.results) {
+					t.Fatalf("checked %d times, want the loop to stop after %d", checks, tt.checks)
+				}
+				if tt.results[checks-1] == 'f' {
+					return errPing
+				}
+				return nil
+			}
+			err := healthcheckLoop(check, 3, time.Millisecond)
+			if !errors.Is(err, errPing) || checks != tt.checks {
+				t.Errorf("got error %v after %d checks, want %v after %d", err, checks, errPing, tt.checks)
+			}
+		})
+	}
+}
+
+func TestSave(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	ct := newTestTransaction(t, testTxID("a"), "")
+	ct.Checks = 3
+	ct.Monitoring = false
+	ct.Error = "exceeded checks threshold"
+	if err := ct.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	unknown := &Transaction{ID: testTxID("b"), Blockchain: "eth", Monitoring: true}
+	if err := unknown.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var n int64
+	DB.Model(&Transaction{}).Count(&n)
+	if n != 1 {
+		t.Errorf("got %d rows after saving, want 1", n)
+	}
+	st := &Transaction{}
+	DB.Find(st, "id = ?", ct.ID)
+	if st.Checks != 3 || st.Monitoring || st.Error != ct.Error {
+		t.Errorf("got checks=%d monitoring=%v error=%q, want the saved state", st.Checks, st.Monitoring, st.Error)
+	}
+}
+
+func TestCheckMonitoredTransactions(t *testing.T) {
+	t.Setenv("MONITOR_PAGE_SIZE", "7")
+	t.Setenv("MONITOR_WORKERS", "4")
+	setupTestDB(t)
+	lastCheckedMu.Lock()
+	delete(lastChecked, "eth")
+	lastCheckedMu.Unlock()
+	var mu sync.Mutex
+	checked := make(map[string]int)
+	setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+		if method == "eth_getTransactionByHash" {
+			var id string
+			json.Unmarshal(params[0], &id)
+			mu.Lock()
+			checked[id]++
+			mu.Unlock()
+		}
+		return nil, 0
+	})
+	want := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		id := "0x" + fmt.Sprintf("%064x", i)
+		newTestTransaction(t, id, "")
+		want[id] = 1
+	}
+	if err := CheckMonitoredTransactions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(checked, want) {
+		for id := range want {
+			if checked[id] != 1 {
+				t.Errorf("%s was checked %d times, want once", id, checked[id])
+			}
+		}
+	}
+}
+
+func TestCheckSuccessUnknownBlockchain(t *testing.T) {
+	setupTestDB(t)
+	ctx := context.Background()
+	ct := &Transaction{ID: testTxID("a"), Blockchain: "removed", Monitoring: true}
+	if err := DB.Create(ct).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.CheckSuccess(ctx); !errors.Is(err, ErrClientNotFound) {
+		t.Errorf("CheckSuccess returned %v, want ErrClientNotFound", err)
+	}
+	monitored, _ := MonitoredTransactions(ctx)
+	if ct.Monitoring || ct.Error != ErrClientNotFound.Error() || len(monitored) != 0 {
+		t.Errorf("got monitoring=%v error=%q and %d monitored, want monitoring stopped", ct.Monitoring, ct.Error, len(monitored))
+	}
+	AddBlockchain("removed")
+	t.Cleanup(func() { delete(Clients, "removed") })
+	if err := ResumeConfiguredBlockchains(ctx); err != nil {
+		t.Fatal(err)
+	}
+	st := &Transaction{}
+	DB.Find(st, "id = ?", ct.ID)
+	if !st.Monitoring || st.Error != "" {
+		t.Errorf("got monitoring=%v error=%q, want monitoring resumed once the blockchain is configured", st.Monitoring, st.Error)
+	}
+}
+
+func TestChecksThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold string
+		override  string
+		checks    int
+		exceeded  bool
+	}{
+		{"unset under the default", "", "", 100, false},
+		{"unset over the default", "", "", 101, true},
+		{"invalid", "never", "", 101, true},
+		{"configured", "5", "", 6, true},
+		{"blockchain override", "5", "50", 6, false},
+		{"over the blockchain override", "5", "50", 51, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// runs last, once the blockchain and env vars are restored
+			t.Cleanup(ConfigureChecksThresholds)
+			AddBlockchain("eth")
+			t.Cleanup(func() { delete(Clients, "eth") })
+			t.Setenv("CHECKS_THRESHOLD_eth", tt.override)
+			setChecksThreshold(t, tt.threshold)
+			ct := &Transaction{ID: testTxID("a"), Blockchain: "eth", Monitoring: true, Checks: tt.checks}
+			ct.ChecksThreshold()
+			if exceeded := ct.Error == "exceeded checks threshold" && !ct.Monitoring; exceeded != tt.exceeded {
+				t.Errorf("after %d checks got error=%q monitoring=%v, want exceeded=%v", tt.checks, ct.Error, ct.Monitoring, tt.exceeded)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package etx
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// NewHeads receives a value when a new block arrives on a subscribed
+	// blockchain, so that the monitor can run without waiting for its timer
+	NewHeads = make(chan struct{}, 1)
+
+	// subscribed tracks the blockchains with an active newHeads subscription,
+	// and headArrived those which received a block since they were last checked
+	subscribed  = make(map[string]bool)
+	headArrived = make(map[string]bool)
+	headsMu     sync.Mutex
+)
+
+// SubscribeNewHeadsEnabled returns true if SUBSCRIBE_NEW_HEADS is set to true, in
+// which case the transactions of blockchains whose client supports subscriptions
+// are checked when a new block arrives rather than on a timer
+func SubscribeNewHeadsEnabled() bool {
+	return os.Getenv("SUBSCRIBE_NEW_HEADS") == "true"
+}
+
+// headDue reports whether a blockchain with a newHeads subscription has received
+// a block since it was last checked, clearing the flag. The second value is false
+// if the blockchain has no subscription and should be polled instead
+func headDue(name string) (bool, bool) {
+	headsMu.Lock()
+	defer headsMu.Unlock()
+	if !subscribed[name] {
+		return false, false
+	}
+	due := headArrived[name]
+	headArrived[name] = false
+	return due, true
+}
+
+// setSubscribed records whether a blockchain has an active newHeads subscription
+func setSubscribed(name string, s bool) {
+	headsMu.Lock()
+	defer headsMu.Unlock()
+	subscribed[name] = s
+	// check once when falling back to polling, or when a new subscription
+	// starts, so no block is missed in between
+	headArrived[name] = true
+}
+
+// subscribeNewHeads subscribes to the new blocks of a blockchain until the
+// subscription fails or ctx is cancelled
+func subscribeNewHeads(ctx context.Context, name string) error {
+	c, err := GetBlockchainClient(name)
+	if err != nil {
+		return err
+	}
+	heads := make(chan *types.Header)
+	sub, err := c.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+	setSubscribed(name, true)
+	defer setSubscribed(name, false)
+	l := log.WithFields(log.Fields{
+		"action":     "subscribeNewHeads",
+		"blockchain": name,
+	})
+	l.Print("subscribed")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-sub.Err():
+			return err
+		case h := <-heads:
+			l.Debugf("block=%d", h.Number.Uint64())
+			headsMu.Lock()
+			headArrived[name] = true
+			headsMu.Unlock()
+			select {
+			case NewHeads <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// HeadSubscriber subscribes to the new blocks of every configured blockchain
+// when SUBSCRIBE_NEW_HEADS is enabled. Blockchains whose client does not support
+// subscriptions, such as HTTP endpoints, keep being polled. Failed subscriptions
+// fall back to polling and are retried after retryInterval
+func HeadSubscriber(ctx context.Context, retryInterval time.Duration) {
+	if !SubscribeNewHeadsEnabled() {
+		return
+	}
+	for _, name := range BlockchainNames() {
+		go func(name string) {
+			for {
+				err := subscribeNewHeads(ctx, name)
+				if ctx.Err() != nil {
+					return
+				}
+				l := log.WithFields(log.Fields{
+					"action":     "HeadSubscriber",
+					"blockchain": name,
+				})
+				if err == rpc.ErrNotificationsUnsupported {
+					l.Print("subscriptions not supported, polling")
+					return
+				}
+				l.Printf("error %v, polling", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryInterval):
+				}
+			}
+		}(name)
+	}
+}
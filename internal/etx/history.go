@@ -0,0 +1,80 @@
+package etx
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// TransactionEvent records a change in the state of a transaction
+type TransactionEvent struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	TransactionID string    `json:"txid" gorm:"index"`
+	OldState      string    `json:"oldState"`
+	NewState      string    `json:"newState"`
+	Checks        int       `json:"checks"`
+	Error         string    `json:"error"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// State returns the state of a transaction, which is "monitoring" until it
+// resolves as "success", "dropped" or "failed"
+func (t *Transaction) State() string {
+	switch {
+	case t.Monitoring:
+		return "monitoring"
+	case t.Success:
+		return "success"
+	case t.Dropped:
+		return "dropped"
+	default:
+		return "failed"
+	}
+}
+
+// AfterFind records the state a transaction was loaded with, so that
+// recordStateChange can tell when it changes
+func (t *Transaction) AfterFind(tx *gorm.DB) error {
+	t.savedState = t.State()
+	return nil
+}
+
+// recordStateChange adds a TransactionEvent if the state of the transaction
+// changed since it was loaded or last saved
+func (t *Transaction) recordStateChange(ctx context.Context) {
+	state := t.State()
+	if state == t.savedState {
+		return
+	}
+	e := &TransactionEvent{
+		TransactionID: t.ID,
+		OldState:      t.savedState,
+		NewState:      state,
+		Checks:        t.Checks,
+		Error:         t.Error,
+	}
+	if err := DB.WithContext(ctx).Create(e).Error; err != nil {
+		log.WithFields(log.Fields{
+			"action": "transaction.recordStateChange",
+			"txid":   t.ID,
+		}).Printf("error %v", err)
+		return
+	}
+	t.savedState = state
+}
+
+// History returns the state changes of a transaction, oldest first
+func (t *Transaction) History(ctx context.Context) ([]TransactionEvent, error) {
+	res := DB.WithContext(ctx).Scopes(t.tenantScope).Find(&Transaction{}, "id = ?", t.ID)
+	if res.Error != nil {
+		return nil, backendError(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	events := []TransactionEvent{}
+	err := DB.WithContext(ctx).Where("transaction_id = ?", t.ID).Order("id asc").Find(&events).Error
+	return events, backendError(err)
+}
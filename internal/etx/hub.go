@@ -0,0 +1,85 @@
+package etx
+
+import (
+	"sync"
+)
+
+// hubBufferSize is the number of recent events kept for clients
+// catching up after a reconnect
+const hubBufferSize = 256
+
+// Event is a transaction state change published by a Hub
+type Event struct {
+	ID          uint64
+	Transaction Transaction
+}
+
+// Hub broadcasts transaction state changes to subscribers
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	nextID uint64
+	buffer []Event
+}
+
+// Updates is the hub which receives every transaction saved by the monitor
+var Updates = NewHub()
+
+// NewHub creates an empty Hub
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel which receives every published event
+// until it is passed to Unsubscribe
+func (h *Hub) Subscribe() chan Event {
+	ch := make(chan Event, 64)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe removes and closes a subscription channel
+func (h *Hub) Unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Since returns the buffered events published after the event with the provided ID
+func (h *Hub) Since(id uint64) []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var evs []Event
+	for _, ev := range h.buffer {
+		if ev.ID > id {
+			evs = append(evs, ev)
+		}
+	}
+	return evs
+}
+
+// Publish sends a transaction to all subscribers. Subscribers which are not
+// keeping up have the update dropped rather than blocking the monitor
+func (h *Hub) Publish(t Transaction) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	ev := Event{ID: h.nextID, Transaction: t}
+	h.buffer = append(h.buffer, ev)
+	if len(h.buffer) > hubBufferSize {
+		h.buffer = h.buffer[len(h.buffer)-hubBufferSize:]
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
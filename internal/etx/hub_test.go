@@ -0,0 +1,54 @@
+package etx
+
+import (
+	"testing"
+)
+
+func TestHub(t *testing.T) {
+	h := NewHub()
+	sub := h.Subscribe()
+	h.Publish(Transaction{ID: testTxID("a")})
+	if ev := <-sub; ev.ID != 1 || ev.Transaction.ID != testTxID("a") {
+		t.Errorf("got event %d for %s, want event 1 for %s", ev.ID, ev.Transaction.ID, testTxID("a"))
+	}
+	h.Unsubscribe(sub)
+	if _, ok := <-sub; ok {
+		t.Errorf("subscription was not closed by Unsubscribe")
+	}
+	if len(h.subs) != 0 {
+		t.Errorf("got %d subscribers after Unsubscribe, want 0", len(h.subs))
+	}
+	// publishing without subscribers or to a full subscriber does not block
+	full := h.Subscribe()
+	for i := 0; i < cap(full)+hubBufferSize; i++ {
+		h.Publish(Transaction{ID: testTxID("b")})
+	}
+	if len(full) != cap(full) {
+		t.Errorf("got %d queued events, want %d", len(full), cap(full))
+	}
+	h.Unsubscribe(full)
+}
+
+func TestHubSince(t *testing.T) {
+	h := NewHub()
+	for i := 0; i < hubBufferSize+10; i++ {
+		h.Publish(Transaction{})
+	}
+	tests := []struct {
+		id    uint64
+		count int
+	}{
+		{0, hubBufferSize},
+		{hubBufferSize, 10},
+		{hubBufferSize + 10, 0},
+	}
+	for _, tt := range tests {
+		evs := h.Since(tt.id)
+		if len(evs) != tt.count {
+			t.Errorf("Since(%d) returned %d events, want %d", tt.id, len(evs), tt.count)
+		}
+		if len(evs) > 0 && evs[0].ID <= tt.id {
+			t.Errorf("Since(%d) returned event %d", tt.id, evs[0].ID)
+		}
+	}
+}
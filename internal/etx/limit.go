@@ -0,0 +1,49 @@
+package etx
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+var (
+	// rpcSemaphores limits the concurrent checks made against each client
+	rpcSemaphores   = make(map[*ethclient.Client]chan struct{})
+	rpcSemaphoresMu sync.Mutex
+)
+
+// RPCMaxConcurrency returns the maximum number of transactions checked at once
+// against a single RPC endpoint, configured with RPC_MAX_CONCURRENCY. Zero,
+// the default, does not limit concurrency
+func RPCMaxConcurrency() int {
+	mc, merr := strconv.Atoi(os.Getenv("RPC_MAX_CONCURRENCY"))
+	if merr != nil || mc < 0 {
+		return 0
+	}
+	return mc
+}
+
+// acquireRPC waits until fewer than RPCMaxConcurrency checks are using the client,
+// or until ctx is done. The returned func must be called to release the client
+func acquireRPC(ctx context.Context, c *ethclient.Client) (func(), error) {
+	max := RPCMaxConcurrency()
+	if max == 0 {
+		return func() {}, nil
+	}
+	rpcSemaphoresMu.Lock()
+	sem, ok := rpcSemaphores[c]
+	if !ok {
+		sem = make(chan struct{}, max)
+		rpcSemaphores[c] = sem
+	}
+	rpcSemaphoresMu.Unlock()
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
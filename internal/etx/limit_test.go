@@ -0,0 +1,110 @@
+package etx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func TestRPCMaxConcurrency(t *testing.T) {
+	tests := []struct {
+		env  string
+		want int
+	}{
+		{"", 0},
+		{"4", 4},
+		{"0", 0},
+		{"-1", 0},
+		{"many", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv("RPC_MAX_CONCURRENCY", tt.env)
+			if got := RPCMaxConcurrency(); got != tt.want {
+				t.Errorf("RPCMaxConcurrency() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcquireRPC(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit string
+		calls int
+		want  int
+	}{
+		{"limited", "2", 10, 2},
+		{"single", "1", 5, 1},
+		{"unlimited", "", 5, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RPC_MAX_CONCURRENCY", tt.limit)
+			c := new(ethclient.Client)
+			t.Cleanup(func() {
+				rpcSemaphoresMu.Lock()
+				delete(rpcSemaphores, c)
+				rpcSemaphoresMu.Unlock()
+			})
+			var (
+				mu           sync.Mutex
+				active, peak int
+				wg           sync.WaitGroup
+				start        = make(chan struct{})
+			)
+			for i := 0; i < tt.calls; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					release, err := acquireRPC(context.Background(), c)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer release()
+					mu.Lock()
+					active++
+					if active > peak {
+						peak = active
+					}
+					mu.Unlock()
+					time.Sleep(50 * time.Millisecond)
+					mu.Lock()
+					active--
+					mu.Unlock()
+				}()
+			}
+			close(start)
+			wg.Wait()
+			if peak != tt.want {
+				t.Errorf("peak concurrency = %d, want %d", peak, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcquireRPCCanceled(t *testing.T) {
+	t.Setenv("RPC_MAX_CONCURRENCY", "1")
+	c := new(ethclient.Client)
+	t.Cleanup(func() {
+		rpcSemaphoresMu.Lock()
+		delete(rpcSemaphores, c)
+		rpcSemaphoresMu.Unlock()
+	})
+	release, err := acquireRPC(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := acquireRPC(ctx, c); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquireRPC() on a full client = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
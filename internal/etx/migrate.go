@@ -0,0 +1,59 @@
+package etx
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// compositeIndex is an index over several columns of a model. Composite indexes
+// are created by Migrate rather than with struct tags, since tag index names are
+// not prefixed and index names are shared by every table in a schema
+type compositeIndex struct {
+	model   interface{}
+	name    string
+	unique  bool
+	columns []string
+}
+
+// compositeIndexes are created by Migrate, named after the table of their model
+// such as idx_txwatch_transactions_monitored
+var compositeIndexes = []compositeIndex{
+	// selects the transactions which are due for a check
+	{&Transaction{}, "monitored", false, []string{"monitoring", "reviewed", "next_check_at"}},
+	// allows an address to be watched once per blockchain and tenant
+	{&WatchedAddress{}, "address", true, []string{"address", "blockchain", "tenant_id"}},
+}
+
+// Migrate creates or updates the tables and indexes of the etx models in db
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&Transaction{}, &WatchedAddress{}, &TransactionEvent{}); err != nil {
+		return err
+	}
+	for _, ci := range compositeIndexes {
+		if err := ci.create(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// create creates the index unless it already exists
+func (ci compositeIndex) create(db *gorm.DB) error {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(ci.model); err != nil {
+		return err
+	}
+	name := db.NamingStrategy.IndexName(stmt.Schema.Table, ci.name)
+	if db.Migrator().HasIndex(ci.model, name) {
+		return nil
+	}
+	cols := make([]interface{}, len(ci.columns))
+	for i, c := range ci.columns {
+		cols[i] = clause.Column{Name: c}
+	}
+	sql := "CREATE INDEX ? ON ? ?"
+	if ci.unique {
+		sql = "CREATE UNIQUE INDEX ? ON ? ?"
+	}
+	return db.Exec(sql, clause.Column{Name: name}, clause.Table{Name: stmt.Schema.Table}, cols).Error
+}
@@ -0,0 +1,107 @@
+package etx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Notifier sends an alert about a transaction to an external system
+type Notifier interface {
+	Notify(ctx context.Context, t *Transaction) error
+}
+
+// Notifiers are the configured notifiers which are alerted when a
+// transaction fails or exceeds the checks threshold
+var Notifiers []Notifier
+
+// SlackNotifier posts a message to a Slack incoming webhook
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+// Notify posts a summary of the transaction to the Slack webhook
+func (s *SlackNotifier) Notify(ctx context.Context, t *Transaction) error {
+	msg := map[string]string{
+		"text": fmt.Sprintf("txwatch: transaction %s on %s failed: %s", t.ID, t.Blockchain, t.Error),
+	}
+	jd, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, s.Client, s.WebhookURL, jd)
+}
+
+// HTTPNotifier posts the transaction JSON to a URL
+type HTTPNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// Notify posts the transaction JSON to the notifier URL
+func (h *HTTPNotifier) Notify(ctx context.Context, t *Transaction) error {
+	jd, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, h.Client, h.URL, jd)
+}
+
+// postNotification POSTs a JSON body and fails on a non-2xx response
+func postNotification(ctx context.Context, c *http.Client, url string, body []byte) error {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("notification returned %s", res.Status)
+	}
+	return nil
+}
+
+// ConfigureNotifiers registers the notifiers configured with the
+// NOTIFY_SLACK_WEBHOOK_URL and NOTIFY_WEBHOOK_URL env vars
+func ConfigureNotifiers() {
+	Notifiers = nil
+	if u := os.Getenv("NOTIFY_SLACK_WEBHOOK_URL"); u != "" {
+		Notifiers = append(Notifiers, &SlackNotifier{WebhookURL: u})
+	}
+	if u := os.Getenv("NOTIFY_WEBHOOK_URL"); u != "" {
+		Notifiers = append(Notifiers, &HTTPNotifier{URL: u})
+	}
+	log.WithFields(log.Fields{
+		"action": "ConfigureNotifiers",
+	}).Printf("notifiers=%d", len(Notifiers))
+}
+
+// Notify sends the transaction to every configured notifier in the background
+// so that slow notifiers do not stall the monitor
+func Notify(t Transaction) {
+	for _, n := range Notifiers {
+		go func(n Notifier) {
+			ctx, cancel := context.WithTimeout(context.Background(), CallbackTimeout())
+			defer cancel()
+			if err := n.Notify(ctx, &t); err != nil {
+				log.WithFields(log.Fields{
+					"action": "Notify",
+					"txid":   t.ID,
+				}).Printf("error %v", err)
+			}
+		}(n)
+	}
+}
@@ -0,0 +1,160 @@
+package etx
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifiers(t *testing.T) {
+	tx := &Transaction{ID: testTxID("a"), Blockchain: "eth", Error: "exceeded checks threshold"}
+	tests := []struct {
+		name   string
+		new    func(url string) Notifier
+		status int
+		fail   bool
+		check  func(t *testing.T, body map[string]interface{})
+	}{
+		{
+			name:   "slack",
+			new:    func(url string) Notifier { return &SlackNotifier{WebhookURL: url} },
+			status: http.StatusOK,
+			check: func(t *testing.T, body map[string]interface{}) {
+				text, _ := body["text"].(string)
+				if !strings.Contains(text, tx.ID) || !strings.Contains(text, tx.Error) {
+					t.Errorf("got Slack text %q, want the txid and error", text)
+				}
+			},
+		},
+		{
+			name:   "http",
+			new:    func(url string) Notifier { return &HTTPNotifier{URL: url} },
+			status: http.StatusAccepted,
+			check: func(t *testing.T, body map[string]interface{}) {
+				if body["txid"] != tx.ID || body["error"] != tx.Error {
+					t.Errorf("got payload %v, want the transaction", body)
+				}
+			},
+		},
+		{
+			name:   "rejected",
+			new:    func(url string) Notifier { return &HTTPNotifier{URL: url} },
+			status: http.StatusInternalServerError,
+			fail:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				bd, _ := ioutil.ReadAll(r.Body)
+				json.Unmarshal(bd, &body)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			err := tt.new(srv.URL).Notify(context.Background(), tx)
+			if (err != nil) != tt.fail {
+				t.Fatalf("Notify returned %v, want failure %v", err, tt.fail)
+			}
+			if tt.check != nil {
+				tt.check(t, body)
+			}
+		})
+	}
+}
+
+func TestConfigureNotifiers(t *testing.T) {
+	t.Cleanup(func() { Notifiers = nil })
+	tests := []struct {
+		slack, webhook string
+		count          int
+	}{
+		{"", "", 0},
+		{"https://hooks.slack.com/x", "", 1},
+		{"", "https://example.com/hook", 1},
+		{"https://hooks.slack.com/x", "https://example.com/hook", 2},
+	}
+	for _, tt := range tests {
+		t.Setenv("NOTIFY_SLACK_WEBHOOK_URL", tt.slack)
+		t.Setenv("NOTIFY_WEBHOOK_URL", tt.webhook)
+		ConfigureNotifiers()
+		if len(Notifiers) != tt.count {
+			t.Errorf("slack=%q webhook=%q configured %d notifiers, want %d", tt.slack, tt.webhook, len(Notifiers), tt.count)
+		}
+	}
+}
+
+// blockingNotifier blocks until it is released, recording the notified transactions
+type blockingNotifier struct {
+	release  chan struct{}
+	notified chan string
+}
+
+func (b *blockingNotifier) Notify(ctx context.Context, t *Transaction) error {
+	<-b.release
+	b.notified <- t.ID
+	return nil
+}
+
+func TestNotifyDoesNotBlock(t *testing.T) {
+	n := &blockingNotifier{release: make(chan struct{}), notified: make(chan string, 1)}
+	Notifiers = []Notifier{n}
+	t.Cleanup(func() { Notifiers = nil })
+	done := make(chan struct{})
+	go func() {
+		Notify(Transaction{ID: testTxID("a")})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Notify blocked on a slow notifier")
+	}
+	close(n.release)
+	if id := <-n.notified; id != testTxID("a") {
+		t.Errorf("notified %s, want %s", id, testTxID("a"))
+	}
+}
+
+func TestSaveNotifiesFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		monitoring bool
+		success    bool
+		notified   bool
+	}{
+		{"failed", false, false, true},
+		{"succeeded", false, true, false},
+		{"monitored", true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			n := &blockingNotifier{release: make(chan struct{}), notified: make(chan string, 1)}
+			close(n.release)
+			Notifiers = []Notifier{n}
+			t.Cleanup(func() { Notifiers = nil })
+			ct := newTestTransaction(t, testTxID("a"), "")
+			ct.Monitoring = tt.monitoring
+			ct.Success = tt.success
+			if err := ct.Save(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-n.notified:
+				if !tt.notified {
+					t.Errorf("got a notification, want none")
+				}
+			case <-time.After(time.Millisecond * 200):
+				if tt.notified {
+					t.Errorf("got no notification, want one")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package etx
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// RetentionDays returns the number of days resolved and reviewed transactions
+// are kept, configured with RETENTION_DAYS. Zero disables pruning
+func RetentionDays() int {
+	rd, rerr := strconv.Atoi(os.Getenv("RETENTION_DAYS"))
+	if rerr != nil || rd < 0 {
+		return 0
+	}
+	return rd
+}
+
+// PruneTransactions permanently deletes the transactions, and their history, which
+// are no longer monitored, have been reviewed and resolved before cutoff. The
+// number of deleted transactions is returned
+func PruneTransactions(ctx context.Context, cutoff time.Time) (int64, error) {
+	var deleted int64
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		const expiredCond = "monitoring = ? AND reviewed = ? AND COALESCE(resolved_at, updated_at) < ?"
+		expired := tx.Unscoped().Model(&Transaction{}).Select("id").Where(expiredCond, false, true, cutoff)
+		if err := tx.Where("transaction_id IN (?)", expired).Delete(&TransactionEvent{}).Error; err != nil {
+			return err
+		}
+		res := tx.Unscoped().Where(expiredCond, false, true, cutoff).Delete(&Transaction{})
+		deleted = res.RowsAffected
+		return res.Error
+	})
+	return deleted, err
+}
+
+// Pruner runs PruneTransactions on the provided interval until ctx is cancelled,
+// deleting transactions resolved more than RetentionDays ago. It returns
+// immediately when RETENTION_DAYS is not set
+func Pruner(ctx context.Context, interval time.Duration) {
+	days := RetentionDays()
+	if days == 0 {
+		return
+	}
+	l := log.WithFields(log.Fields{
+		"action": "Pruner",
+	})
+	for {
+		cutoff := time.Now().AddDate(0, 0, -days)
+		deleted, err := PruneTransactions(ctx, cutoff)
+		if err != nil {
+			l.Printf("error %v", err)
+		} else {
+			l.Printf("cutoff=%s deleted=%d", cutoff.Format(time.RFC3339), deleted)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
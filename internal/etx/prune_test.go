@@ -0,0 +1,71 @@
+package etx
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestPruneTransactions(t *testing.T) {
+	setupTestDB(t)
+	now := time.Now()
+	old, recent := now.AddDate(0, 0, -40), now.AddDate(0, 0, -10)
+	cutoff := now.AddDate(0, 0, -30)
+	tests := []struct {
+		name   string
+		tx     Transaction
+		pruned bool
+	}{
+		{"old reviewed", Transaction{ResolvedAt: &old, Reviewed: true}, true},
+		{"old reviewed without resolvedAt", Transaction{Model: gorm.Model{CreatedAt: old, UpdatedAt: old}, Reviewed: true}, true},
+		{"recent reviewed", Transaction{ResolvedAt: &recent, Reviewed: true}, false},
+		{"old unreviewed", Transaction{ResolvedAt: &old}, false},
+		{"old monitored", Transaction{ResolvedAt: &old, Reviewed: true, Monitoring: true}, false},
+	}
+	for i := range tests {
+		tx := &tests[i].tx
+		tx.ID = testTxID(string(rune('a' + i)))
+		tx.Blockchain = "eth"
+		if err := DB.Create(tx).Error; err != nil {
+			t.Fatal(err)
+		}
+		DB.Create(&TransactionEvent{TransactionID: tx.ID, NewState: "success"})
+	}
+	deleted, err := PruneTransactions(context.Background(), cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Errorf("deleted %d transactions, want 2", deleted)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var txs, events int64
+			DB.Unscoped().Model(&Transaction{}).Where("id = ?", tt.tx.ID).Count(&txs)
+			DB.Model(&TransactionEvent{}).Where("transaction_id = ?", tt.tx.ID).Count(&events)
+			if pruned := txs == 0 && events == 0; pruned != tt.pruned {
+				t.Errorf("got %d transactions and %d events, want pruned=%v", txs, events, tt.pruned)
+			}
+		})
+	}
+}
+
+func TestRetentionDays(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 0},
+		{"30", 30},
+		{"-1", 0},
+		{"month", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("RETENTION_DAYS", tt.value)
+		if got := RetentionDays(); got != tt.want {
+			t.Errorf("RETENTION_DAYS=%q returned %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
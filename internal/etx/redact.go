@@ -0,0 +1,51 @@
+package etx
+
+import (
+	"net/url"
+	"regexp"
+)
+
+var (
+	// urlRegexp matches the URLs in a message, such as those in RPC and callback errors
+	urlRegexp = regexp.MustCompile("[a-zA-Z][a-zA-Z0-9+.-]*://[^\\s\"'`]*[^\\s\"'`:,.;)]")
+	// dsnPasswordRegexp matches the password of a key/value DSN such as postgres uses
+	dsnPasswordRegexp = regexp.MustCompile("(?i)(password=)('[^']*'|[^\\s'\"`]+)")
+	// dsnUserinfoRegexp matches the password of a user:password@ DSN such as mysql uses
+	dsnUserinfoRegexp = regexp.MustCompile(`([^\s:@/]+):[^\s@]*@(tcp|unix)\(`)
+)
+
+// RedactURL masks the credentials, path and query parameter values of a URL,
+// which providers use to carry API keys, so that it can be logged
+func RedactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "REDACTED"
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	if u.Path != "" && u.Path != "/" {
+		u.Path = "/REDACTED"
+	}
+	u.RawPath = ""
+	if q := u.Query(); len(q) > 0 {
+		for k := range q {
+			q.Set(k, "REDACTED")
+		}
+		u.RawQuery = q.Encode()
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// RedactDSN masks the password of a postgres key/value DSN or a mysql DSN
+func RedactDSN(s string) string {
+	s = dsnPasswordRegexp.ReplaceAllString(s, "${1}REDACTED")
+	return dsnUserinfoRegexp.ReplaceAllString(s, "${1}:REDACTED@${2}(")
+}
+
+// Redact masks the URLs and DSN passwords in a message, such as an error
+// which includes the endpoint or DSN it failed to connect to
+func Redact(s string) string {
+	return RedactDSN(urlRegexp.ReplaceAllStringFunc(s, RedactURL))
+}
@@ -0,0 +1,119 @@
+package etx
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReorgWindowBlocks returns the number of recent blocks in which successful
+// transactions are re-verified, configured with REORG_WINDOW_BLOCKS. Zero
+// disables reorg detection
+func ReorgWindowBlocks() uint64 {
+	rw, rerr := strconv.ParseUint(os.Getenv("REORG_WINDOW_BLOCKS"), 10, 64)
+	if rerr != nil {
+		return 0
+	}
+	return rw
+}
+
+// reopen returns a transaction whose inclusion was reverted by a reorg to monitoring
+func (t *Transaction) reopen(ctx context.Context, reason string) error {
+	log.WithFields(log.Fields{
+		"action": "transaction.reopen",
+		"txid":   t.ID,
+	}).Printf("reopening: %s", reason)
+	t.Monitoring = true
+	t.Pending = true
+	t.Success = false
+	t.Error = ""
+	t.Checks = 0
+	t.Confirmations = 0
+	return t.Save(ctx)
+}
+
+// ReconcileReorgs re-verifies the successful transactions on a blockchain which
+// were mined within the last ReorgWindowBlocks blocks, returning them to
+// monitoring if their receipt has disappeared or its status has changed.
+// Transactions which cannot be verified or updated are logged and skipped
+func ReconcileReorgs(ctx context.Context, name string) error {
+	l := log.WithFields(log.Fields{
+		"action":     "ReconcileReorgs",
+		"blockchain": name,
+	})
+	window := ReorgWindowBlocks()
+	c, err := GetHealthyBlockchainClient(ctx, name)
+	if err != nil {
+		return err
+	}
+	var head uint64
+	err = retryRPC(ctx, "eth.BlockNumber", func(rctx context.Context) (err error) {
+		head, err = c.BlockNumber(rctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var from uint64
+	if head > window {
+		from = head - window
+	}
+	var txs []Transaction
+	if err := DB.WithContext(ctx).Where("blockchain = ? AND success = ? AND monitoring = ? AND block_number >= ?", name, true, false, from).Find(&txs).Error; err != nil {
+		return backendError(err)
+	}
+	l.Printf("head=%d transactions=%d", head, len(txs))
+	for i := range txs {
+		t := &txs[i]
+		var r *types.Receipt
+		err := retryRPC(ctx, "eth.TransactionReceipt", func(rctx context.Context) (err error) {
+			r, err = c.TransactionReceipt(rctx, common.HexToHash(t.ID))
+			return err
+		})
+		switch {
+		case err == ethereum.NotFound:
+			err = t.reopen(ctx, "receipt not found")
+		case err != nil:
+			// the receipt could not be fetched, and is verified on the next run
+		case r.Status == 0:
+			err = t.reopen(ctx, "receipt status changed")
+		case r.BlockNumber.Uint64() != t.BlockNumber:
+			l.Printf("txid=%s moved from block %d to %d", t.ID, t.BlockNumber, r.BlockNumber.Uint64())
+			err = DB.WithContext(ctx).Model(&Transaction{}).Where("id = ?", t.ID).Update("block_number", r.BlockNumber.Uint64()).Error
+		}
+		if err != nil {
+			l.Printf("txid=%s error %v", t.ID, err)
+		}
+	}
+	return nil
+}
+
+// ReorgReconciler runs ReconcileReorgs for every configured blockchain on the
+// provided interval until ctx is cancelled. It returns immediately when
+// REORG_WINDOW_BLOCKS is not set
+func ReorgReconciler(ctx context.Context, interval time.Duration) {
+	if ReorgWindowBlocks() == 0 {
+		return
+	}
+	for {
+		for _, name := range BlockchainNames() {
+			if err := ReconcileReorgs(ctx, name); err != nil {
+				log.WithFields(log.Fields{
+					"action":     "ReorgReconciler",
+					"blockchain": name,
+				}).Printf("error %v", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package etx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestReconcileReorgs(t *testing.T) {
+	t.Setenv("REORG_WINDOW_BLOCKS", "100")
+	t.Setenv("RPC_RETRIES", "2")
+	t.Setenv("RPC_RETRY_BACKOFF", "1")
+	tx, _ := testSignedTx(t, 0, false)
+	moved := testReceipt(tx, 1)
+	moved.BlockNumber = big.NewInt(17)
+	tests := []struct {
+		name        string
+		failures    int
+		receipt     interface{}
+		calls       int
+		monitoring  bool
+		blockNumber uint64
+	}{
+		{"still mined", 0, testReceipt(tx, 1), 1, false, 16},
+		{"receipt not found", 0, nil, 1, true, 16},
+		{"status changed", 0, testReceipt(tx, 0), 1, true, 16},
+		{"moved block", 0, moved, 1, false, 17},
+		{"transient failure", 2, testReceipt(tx, 1), 3, false, 16},
+		{"transient failures exhausted", 3, nil, 3, false, 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			calls := 0
+			setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+				switch method {
+				case "eth_getTransactionReceipt":
+					calls++
+					if calls <= tt.failures {
+						return nil, http.StatusBadGateway
+					}
+					return tt.receipt, 0
+				case "eth_blockNumber":
+					return "0x20", 0
+				}
+				return nil, 0
+			})
+			if err := DB.Create(&Transaction{ID: tx.Hash().Hex(), Blockchain: "eth", Success: true, BlockNumber: 16}).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := ReconcileReorgs(context.Background(), "eth"); err != nil {
+				t.Fatalf("ReconcileReorgs: %v", err)
+			}
+			if calls != tt.calls {
+				t.Errorf("got %d receipt calls, want %d", calls, tt.calls)
+			}
+			st := &Transaction{}
+			DB.Find(st, "id = ?", tx.Hash().Hex())
+			if st.Monitoring != tt.monitoring || st.Success == tt.monitoring || st.BlockNumber != tt.blockNumber {
+				t.Errorf("got monitoring=%v success=%v blockNumber=%d, want monitoring=%v blockNumber=%d",
+					st.Monitoring, st.Success, st.BlockNumber, tt.monitoring, tt.blockNumber)
+			}
+		})
+	}
+}
+
+func TestReconcileReorgsDBError(t *testing.T) {
+	t.Setenv("REORG_WINDOW_BLOCKS", "100")
+	setupTestDB(t)
+	setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+		if method == "eth_blockNumber" {
+			return "0x20", 0
+		}
+		return nil, 0
+	})
+	DB.Callback().Query().Before("gorm:query").Register("test:fail", func(db *gorm.DB) {
+		db.AddError(errors.New("database is locked"))
+	})
+	if err := ReconcileReorgs(context.Background(), "eth"); !errors.Is(err, ErrBackend) {
+		t.Errorf("ReconcileReorgs() = %v, want %v", err, ErrBackend)
+	}
+}
@@ -0,0 +1,108 @@
+package etx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+// dbRetryBackoff is the delay before the first retry of a failed database
+// write, which doubles on each following retry
+const dbRetryBackoff = time.Millisecond * 100
+
+// RPCRetries returns the number of times a failed blockchain RPC call is
+// retried within a single check, configured with RPC_RETRIES and defaulting to 3
+func RPCRetries() int {
+	rr, rerr := strconv.Atoi(os.Getenv("RPC_RETRIES"))
+	if rerr != nil || rr < 0 {
+		return 3
+	}
+	return rr
+}
+
+// RPCRetryBackoff returns the delay before the first retry of a blockchain RPC
+// call, which doubles on each following retry. It is configured in milliseconds
+// with RPC_RETRY_BACKOFF and defaults to 500ms
+func RPCRetryBackoff() time.Duration {
+	rb, rerr := strconv.Atoi(os.Getenv("RPC_RETRY_BACKOFF"))
+	if rerr != nil || rb < 0 {
+		return time.Millisecond * 500
+	}
+	return time.Millisecond * time.Duration(rb)
+}
+
+// retryRPC makes a blockchain RPC call traced with the provided name, retrying
+// it with exponential backoff up to RPCRetries times. ethereum.NotFound is a
+// definitive answer and is returned without retrying
+func retryRPC(ctx context.Context, name string, call func(context.Context) error) error {
+	retries := RPCRetries()
+	backoff := RPCRetryBackoff()
+	for i := 0; ; i++ {
+		rctx, done := rpcContext(ctx, name)
+		err := call(rctx)
+		done(err)
+		if err == nil || err == ethereum.NotFound || i >= retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff << i):
+		}
+	}
+}
+
+// isTransient returns true if an RPC error was caused by a timeout, a network
+// failure, a closed connection or an HTTP error from the endpoint rather than
+// a response from the node about the transaction
+func isTransient(err error) bool {
+	var ne net.Error
+	var he rpc.HTTPError
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &ne) ||
+		errors.Is(err, rpc.ErrClientQuit) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &he)
+}
+
+// isConnectionError returns true if a check failed because none of the
+// blockchain's endpoints could be reached, rather than because of the transaction
+func isConnectionError(err error) bool {
+	return isTransient(err) || errors.Is(err, ErrNoHealthyClient)
+}
+
+// DBWriteRetries returns the number of times a failed database write of a
+// checked transaction is retried, configured with DB_WRITE_RETRIES and
+// defaulting to 3
+func DBWriteRetries() int {
+	dr, derr := strconv.Atoi(os.Getenv("DB_WRITE_RETRIES"))
+	if derr != nil || dr < 0 {
+		return 3
+	}
+	return dr
+}
+
+// retryDB runs a database write, retrying it with exponential backoff up to
+// DBWriteRetries times so that deadlocks and dropped connections do not lose
+// the result of a check. Each failed attempt is logged to l
+func retryDB(ctx context.Context, l *log.Entry, write func() error) error {
+	retries := DBWriteRetries()
+	for i := 0; ; i++ {
+		err := write()
+		if err == nil || i >= retries || ctx.Err() != nil {
+			return err
+		}
+		l.Warnf("attempt %d failed, retrying: %v", i+1, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(dbRetryBackoff << i):
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package etx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"gorm.io/gorm"
+)
+
+func TestIsConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no healthy client", ErrNoHealthyClient, true},
+		{"wrapped no healthy client", fmt.Errorf("eth: %w", ErrNoHealthyClient), true},
+		{"http error", rpc.HTTPError{StatusCode: 503, Status: "503 Service Unavailable"}, true},
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"rpc error", errors.New("header not found"), false},
+		{"not found", ErrNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnectionError(tt.err); got != tt.want {
+				t.Errorf("isConnectionError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadline exceeded", context.DeadlineExceeded, true},
+		{"client quit", rpc.ErrClientQuit, true},
+		{"eof", io.EOF, true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"http error", rpc.HTTPError{StatusCode: 502, Status: "502 Bad Gateway"}, true},
+		{"wrapped eof", fmt.Errorf("reading: %w", io.EOF), true},
+		{"rpc error", errors.New("execution reverted"), false},
+		{"not found", ErrNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransient(tt.err); got != tt.want {
+				t.Errorf("isTransient(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryRPC(t *testing.T) {
+	t.Setenv("RPC_RETRIES", "2")
+	t.Setenv("RPC_RETRY_BACKOFF", "1")
+	tests := []struct {
+		name  string
+		errs  []error
+		calls int
+		fail  bool
+	}{
+		{"success", nil, 1, false},
+		{"transient then success", []error{io.EOF}, 2, false},
+		{"error then success", []error{errors.New("execution reverted")}, 2, false},
+		{"not found", []error{ethereum.NotFound}, 1, true},
+		{"transient exhausted", []error{io.EOF, io.EOF, io.EOF, io.EOF}, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryRPC(context.Background(), "test", func(context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.calls || (err != nil) != tt.fail {
+				t.Errorf("got %d calls and error %v, want %d calls and failure %v", calls, err, tt.calls, tt.fail)
+			}
+		})
+	}
+}
+
+func TestCheckSuccessRetries(t *testing.T) {
+	t.Setenv("RPC_RETRIES", "2")
+	t.Setenv("RPC_RETRY_BACKOFF", "1")
+	tx, txJSON := testSignedTx(t, 0, false)
+	tests := []struct {
+		name       string
+		failures   int
+		failure    interface{}
+		monitoring bool
+		success    bool
+	}{
+		{"no failures", 0, nil, false, true},
+		{"transient failure", 2, http.StatusBadGateway, false, true},
+		{"transient failures exhausted", 3, http.StatusBadGateway, true, false},
+		{"permanent failure", 3, &rpcError{Code: -32602, Message: "invalid argument"}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			calls := 0
+			setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+				switch method {
+				case "eth_getTransactionByHash":
+					calls++
+					if calls <= tt.failures {
+						if status, ok := tt.failure.(int); ok {
+							return nil, status
+						}
+						return tt.failure, 0
+					}
+					return txJSON, 0
+				case "eth_getTransactionReceipt":
+					return testReceipt(tx, 1), 0
+				case "eth_blockNumber":
+					return "0x20", 0
+				}
+				return nil, 0
+			})
+			ct := newTestTransaction(t, tx.Hash().Hex(), "")
+			ct.CheckSuccess(context.Background())
+			if ct.Monitoring != tt.monitoring || ct.Success != tt.success {
+				t.Errorf("got monitoring=%v success=%v error=%q, want monitoring=%v success=%v",
+					ct.Monitoring, ct.Success, ct.Error, tt.monitoring, tt.success)
+			}
+		})
+	}
+}
+
+func TestSaveRetriesDBWrites(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  string
+		failures int
+		canceled bool
+		attempts int
+		fail     bool
+	}{
+		{"no retries", "0", 1, false, 1, true},
+		{"retries exhausted", "2", 5, false, 3, true},
+		{"recovers", "2", 1, false, 2, false},
+		{"canceled", "3", 5, true, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DB_WRITE_RETRIES", tt.retries)
+			setupTestDB(t)
+			ct := newTestTransaction(t, testTxID("a"), "")
+			attempts := 0
+			DB.Callback().Update().Before("gorm:update").Register("test:fail", func(db *gorm.DB) {
+				attempts++
+				if attempts <= tt.failures {
+					db.AddError(errors.New("database is locked"))
+				}
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.canceled {
+				cancel()
+			}
+			ct.Checks = 1
+			err := ct.Save(ctx)
+			if tt.fail && !errors.Is(err, ErrBackend) {
+				t.Errorf("Save() = %v, want %v", err, ErrBackend)
+			} else if !tt.fail && err != nil {
+				t.Errorf("Save() = %v, want nil", err)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("got %d write attempts, want %d", attempts, tt.attempts)
+			}
+			st := &Transaction{}
+			DB.Find(st, "id = ?", ct.ID)
+			if saved := st.Checks == 1; saved == tt.fail {
+				t.Errorf("got checks=%d after Save() = %v", st.Checks, err)
+			}
+		})
+	}
+}
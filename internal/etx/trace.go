@@ -0,0 +1,26 @@
+package etx
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var tracer = otel.Tracer("github.com/robertlestak/txwatch/internal/etx")
+
+// rpcContext derives a context for a single blockchain RPC call which times out
+// after RPCTimeout and is traced in a span with the provided name. The returned
+// function must be called with the result of the call to end the span
+func rpcContext(ctx context.Context, name string) (context.Context, func(error)) {
+	ctx, cancel := context.WithTimeout(ctx, RPCTimeout())
+	ctx, span := tracer.Start(ctx, name)
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		cancel()
+	}
+}
@@ -0,0 +1,67 @@
+package etx
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// transferTopic is the topic of the ERC-20 Transfer(address,address,uint256) event
+var transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// TokenTransfer is an ERC-20 Transfer event emitted by a transaction
+type TokenTransfer struct {
+	Token string `json:"token"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value string `json:"value"`
+}
+
+type TokenTransfers []TokenTransfer
+
+// NewTokenTransfers decodes the ERC-20 Transfer events in the logs from a
+// transaction receipt. ERC-721 transfers, which share the event signature
+// but index the token ID, are skipped
+func NewTokenTransfers(logs []*types.Log) TokenTransfers {
+	tt := make(TokenTransfers, 0)
+	for _, l := range logs {
+		if len(l.Topics) != 3 || l.Topics[0] != transferTopic || len(l.Data) != 32 {
+			continue
+		}
+		tt = append(tt, TokenTransfer{
+			Token: l.Address.Hex(),
+			From:  common.BytesToAddress(l.Topics[1].Bytes()).Hex(),
+			To:    common.BytesToAddress(l.Topics[2].Bytes()).Hex(),
+			Value: new(big.Int).SetBytes(l.Data).String(),
+		})
+	}
+	return tt
+}
+
+func (TokenTransfers) GormDataType() string {
+	return "bytes"
+}
+
+func (tt TokenTransfers) Value() (driver.Value, error) {
+	if tt == nil {
+		return nil, nil
+	}
+	return json.Marshal(tt)
+}
+
+func (tt *TokenTransfers) Scan(value interface{}) error {
+	if value == nil {
+		*tt = nil
+		return nil
+	}
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("[]byte assertion failed")
+	}
+	return json.Unmarshal(b, tt)
+}
@@ -0,0 +1,141 @@
+package etx
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// workerIdleTimeout is how long a monitor worker above the minimum waits
+// for a transaction before exiting
+const workerIdleTimeout = time.Second * 5
+
+// monitorWorkersEnv parses a worker count env var, returning def if it is unset or invalid
+func monitorWorkersEnv(k string, def int) int {
+	mw := os.Getenv(k)
+	if mw == "" {
+		return def
+	}
+	n, nerr := strconv.Atoi(mw)
+	if nerr != nil || n <= 0 {
+		log.WithFields(log.Fields{
+			"action": "MonitorWorkers",
+		}).Warnf("invalid %s %q, defaulting to %d", k, mw, def)
+		return def
+	}
+	return n
+}
+
+// MonitorWorkersMin returns the number of monitor worker goroutines started for each
+// run, configured with MONITOR_WORKERS_MIN and defaulting to MonitorWorkers
+func MonitorWorkersMin() int {
+	return monitorWorkersEnv("MONITOR_WORKERS_MIN", MonitorWorkers())
+}
+
+// MonitorWorkersMax returns the number of monitor worker goroutines a run can scale up
+// to when there is a backlog, configured with MONITOR_WORKERS_MAX and defaulting to
+// MonitorWorkers. It is never less than MonitorWorkersMin
+func MonitorWorkersMax() int {
+	max := monitorWorkersEnv("MONITOR_WORKERS_MAX", MonitorWorkers())
+	if min := MonitorWorkersMin(); max < min {
+		return min
+	}
+	return max
+}
+
+// workerPool runs between min and max monitor worker goroutines, scaling up with
+// the backlog of transactions and down again when workers are idle
+type workerPool struct {
+	ctx    context.Context
+	min    int
+	max    int
+	tin    chan *Transaction
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	active int
+}
+
+// newWorkerPool starts a pool with the minimum number of workers
+func newWorkerPool(ctx context.Context, min, max int) *workerPool {
+	p := &workerPool{
+		ctx: ctx,
+		min: min,
+		max: max,
+		tin: make(chan *Transaction, max),
+	}
+	p.scale(min)
+	return p
+}
+
+// scale starts workers until there is one for each transaction in the
+// backlog, up to the maximum
+func (p *workerPool) scale(backlog int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	started := 0
+	for p.active < backlog && p.active < p.max {
+		p.active++
+		started++
+		p.wg.Add(1)
+		go p.work()
+	}
+	if started > 0 {
+		log.WithFields(log.Fields{
+			"action": "workerPool.scale",
+		}).Debugf("backlog=%d started=%d active=%d", backlog, started, p.active)
+	}
+}
+
+// work checks transactions until the pool is closed, or until it has been
+// idle for workerIdleTimeout while there are more than the minimum workers
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	idle := time.NewTimer(workerIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case t, ok := <-p.tin:
+			if !ok {
+				return
+			}
+			if err := t.CheckSuccess(p.ctx); errors.Is(err, ErrBackend) {
+				log.WithFields(log.Fields{
+					"action": "workerPool.work",
+					"txid":   t.ID,
+				}).Errorf("check result not saved: %v", err)
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(workerIdleTimeout)
+		case <-idle.C:
+			p.mu.Lock()
+			if p.active > p.min {
+				p.active--
+				p.mu.Unlock()
+				return
+			}
+			p.mu.Unlock()
+			idle.Reset(workerIdleTimeout)
+		}
+	}
+}
+
+// submit scales the pool for a page of transactions and queues them
+func (p *workerPool) submit(txs []*Transaction) {
+	p.scale(len(txs) + len(p.tin))
+	for _, t := range txs {
+		p.tin <- t
+	}
+}
+
+// close stops the pool once the queued transactions have been checked
+func (p *workerPool) close() {
+	close(p.tin)
+	p.wg.Wait()
+}
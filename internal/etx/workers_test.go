@@ -0,0 +1,108 @@
+package etx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMonitorWorkers(t *testing.T) {
+	tests := []struct {
+		workers, min, max string
+		wantMin, wantMax  int
+	}{
+		{"", "", "", 10, 10},
+		{"4", "", "", 4, 4},
+		{"0", "", "", 10, 10},
+		{"many", "", "", 10, 10},
+		{"4", "2", "8", 2, 8},
+		{"4", "6", "3", 6, 6},
+		{"", "-1", "20", 10, 20},
+	}
+	for _, tt := range tests {
+		t.Setenv("MONITOR_WORKERS", tt.workers)
+		t.Setenv("MONITOR_WORKERS_MIN", tt.min)
+		t.Setenv("MONITOR_WORKERS_MAX", tt.max)
+		if min, max := MonitorWorkersMin(), MonitorWorkersMax(); min != tt.wantMin || max != tt.wantMax {
+			t.Errorf("MONITOR_WORKERS=%q MONITOR_WORKERS_MIN=%q MONITOR_WORKERS_MAX=%q got min=%d max=%d, want min=%d max=%d",
+				tt.workers, tt.min, tt.max, min, max, tt.wantMin, tt.wantMax)
+		}
+	}
+}
+
+func TestWorkerPoolConcurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max string
+		txs      int
+		want     int
+	}{
+		{"fixed", "3", "3", 10, 3},
+		{"single", "1", "1", 5, 1},
+		{"scaled to max", "2", "6", 10, 6},
+		{"scaled to backlog", "2", "6", 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MONITOR_WORKERS_MIN", tt.min)
+			t.Setenv("MONITOR_WORKERS_MAX", tt.max)
+			setupTestDB(t)
+			resetLastChecked(t, "eth")
+			var (
+				mu           sync.Mutex
+				active, peak int
+				gate         = make(chan struct{})
+			)
+			// each check blocks in the RPC call until the gate is opened,
+			// so every worker holds one transaction
+			setupTestRPC(t, "eth", func(method string, params []json.RawMessage) (interface{}, int) {
+				if method != "eth_getTransactionByHash" {
+					return nil, 0
+				}
+				mu.Lock()
+				active++
+				if active > peak {
+					peak = active
+				}
+				mu.Unlock()
+				<-gate
+				mu.Lock()
+				active--
+				mu.Unlock()
+				return nil, 0
+			})
+			for i := 0; i < tt.txs; i++ {
+				newTestTransaction(t, "0x"+fmt.Sprintf("%064x", i), "")
+			}
+			done := make(chan error)
+			go func() {
+				done <- CheckMonitoredTransactions(context.Background())
+			}()
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				mu.Lock()
+				n := active
+				mu.Unlock()
+				if n >= tt.want || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			// allow any extra workers to reach the gate
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			got := peak
+			mu.Unlock()
+			close(gate)
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d concurrent checks, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,26 +1,50 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
+	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/robertlestak/txwatch/internal/etx"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 
+	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 )
 
+// writeTransaction sends a transaction as a JSON body with the provided
+// status code, or an internal error if it cannot be encoded
+func writeTransaction(w http.ResponseWriter, t *etx.Transaction, status int) {
+	if err := t.HttpJSONStatus(w, status); err != nil {
+		log.Printf("error %v", err)
+		writeError(w, err, http.StatusInternalServerError)
+	}
+}
+
 // HandleNewTransaction is an HTTP handler to receive a new transaction
 // event and add this transaction to the monitor
 func HandleNewTransaction(w http.ResponseWriter, r *http.Request) {
@@ -32,29 +56,170 @@ func HandleNewTransaction(w http.ResponseWriter, r *http.Request) {
 	bd, berr := ioutil.ReadAll(r.Body)
 	if berr != nil {
 		log.Println(berr)
-		http.Error(w, berr.Error(), http.StatusBadRequest)
+		writeError(w, berr, bodyErrorStatus(berr))
 		return
 	}
 	t := &etx.Transaction{}
-	jerr := json.Unmarshal(bd, &t)
+	jerr := decodeJSON(bd, &t)
 	if jerr != nil {
 		log.Println(jerr)
-		http.Error(w, jerr.Error(), http.StatusBadRequest)
+		writeError(w, jerr, http.StatusBadRequest)
 		return
 	}
+	t.TenantID = tenantID(r)
 	log.WithFields(log.Fields{
 		"action": "HandleNewTransaction",
 	}).Printf("txid=%s blockchainID=%s", t.ID, t.Blockchain)
-	terr := t.New()
-	if terr != nil {
+	if verr := t.Validate(); verr != nil {
+		log.Println(verr)
+		writeError(w, verr, http.StatusBadRequest)
+		return
+	}
+	terr := t.New(r.Context())
+	if terr == etx.ErrExists && r.FormValue("strict") != "true" {
+		writeTransaction(w, t, http.StatusOK)
+		return
+	} else if terr != nil {
 		log.Println(terr)
-		http.Error(w, terr.Error(), http.StatusBadRequest)
+		writeError(w, terr, errorStatus(terr))
+		return
+	}
+	writeTransaction(w, t, http.StatusCreated)
+}
+
+// BulkResult is the outcome of adding one of the transactions of a bulk request
+type BulkResult struct {
+	Status      int              `json:"status"`
+	Error       string           `json:"error,omitempty"`
+	Code        ErrorCode        `json:"code,omitempty"`
+	Transaction *etx.Transaction `json:"transaction,omitempty"`
+}
+
+// maxBulkTransactions returns the largest number of transactions which can be added
+// in a single bulk request, configured with MAX_BULK_TRANSACTIONS and defaulting to 1000
+func maxBulkTransactions() int {
+	return pageSizeEnv("MAX_BULK_TRANSACTIONS", 1000)
+}
+
+// HandleNewTransactions is an HTTP handler to add a list of transactions to the
+// monitor. Each transaction is validated and added independently, so one request
+// can mix blockchains, and the result of each is returned in the request order
+func HandleNewTransactions(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"action": "HandleNewTransactions",
+	}).Println("New Transactions Request")
+	defer r.Body.Close()
+	bd, berr := ioutil.ReadAll(r.Body)
+	if berr != nil {
+		log.Printf("error %v", berr)
+		writeError(w, berr, bodyErrorStatus(berr))
+		return
+	}
+	var txs []*etx.Transaction
+	if jerr := decodeJSON(bd, &txs); jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusBadRequest)
+		return
+	}
+	if len(txs) == 0 {
+		jsonError(w, "no transactions provided", http.StatusBadRequest)
+		return
+	}
+	if max := maxBulkTransactions(); len(txs) > max {
+		jsonError(w, fmt.Sprintf("at most %d transactions can be added at once", max), http.StatusBadRequest)
+		return
+	}
+	results := make([]BulkResult, len(txs))
+	valid := []int{}
+	for i, t := range txs {
+		if t == nil {
+			results[i] = BulkResult{Status: http.StatusBadRequest, Error: "transaction is null", Code: CodeBadRequest}
+			continue
+		}
+		t.TenantID = tenantID(r)
+		if verr := t.Validate(); verr != nil {
+			results[i] = BulkResult{Status: http.StatusBadRequest, Error: verr.Error(), Code: errorCode(verr, http.StatusBadRequest)}
+			continue
+		}
+		valid = append(valid, i)
+	}
+	// add the transactions of each blockchain together
+	sort.SliceStable(valid, func(a, b int) bool {
+		return txs[valid[a]].Blockchain < txs[valid[b]].Blockchain
+	})
+	strict := r.FormValue("strict") == "true"
+	for _, i := range valid {
+		t := txs[i]
+		terr := t.New(r.Context())
+		switch {
+		case terr == nil:
+			results[i] = BulkResult{Status: http.StatusCreated, Transaction: t}
+		case terr == etx.ErrExists && !strict:
+			results[i] = BulkResult{Status: http.StatusOK, Transaction: t}
+		default:
+			log.WithFields(log.Fields{
+				"action": "HandleNewTransactions",
+				"txid":   t.ID,
+			}).Printf("error %v", terr)
+			status := errorStatus(terr)
+			results[i] = BulkResult{Status: status, Error: terr.Error(), Code: errorCode(terr, status)}
+		}
+	}
+	jd, jerr := json.Marshal(results)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jd))
+}
+
+// HandleValidateTransaction is an HTTP handler to check whether the
+// transaction in the request body exists on its blockchain without
+// adding it to the monitor
+func HandleValidateTransaction(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"action": "HandleValidateTransaction",
+	}).Println("Validate Transaction Request")
+	defer r.Body.Close()
+	bd, berr := ioutil.ReadAll(r.Body)
+	if berr != nil {
+		log.Printf("error %v", berr)
+		writeError(w, berr, bodyErrorStatus(berr))
+		return
+	}
+	t := &etx.Transaction{}
+	jerr := decodeJSON(bd, t)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusBadRequest)
+		return
+	}
+	if verr := t.Validate(); verr != nil {
+		log.Printf("error %v", verr)
+		writeError(w, verr, http.StatusBadRequest)
+		return
+	}
+	lr, lerr := t.Lookup(r.Context())
+	if lerr != nil {
+		log.Printf("error %v", lerr)
+		writeError(w, lerr, http.StatusBadGateway)
 		return
 	}
+	jd, jerr := json.Marshal(lr)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jd))
 }
 
 // HandleSetReviewed is an HTTP handler to receive a request
-// to set the "reviewed" state of a transaction by txid
+// to set the "reviewed" state of a transaction by txid. The
+// request body must explicitly set reviewed to true or false
 func HandleSetReviewed(w http.ResponseWriter, r *http.Request) {
 	log.WithFields(log.Fields{
 		"action": "HandleSetReviewed",
@@ -65,151 +230,1370 @@ func HandleSetReviewed(w http.ResponseWriter, r *http.Request) {
 	bd, berr := ioutil.ReadAll(r.Body)
 	if berr != nil {
 		log.Println(berr)
-		http.Error(w, berr.Error(), http.StatusBadRequest)
+		writeError(w, berr, bodyErrorStatus(berr))
 		return
 	}
-	t := &etx.Transaction{}
-	jerr := json.Unmarshal(bd, &t)
+	// reviewed is a pointer so that a missing value is rejected rather
+	// than un-reviewing the transaction
+	req := struct {
+		Reviewed *bool `json:"reviewed"`
+	}{}
+	jerr := decodeJSON(bd, &req)
 	if jerr != nil {
 		log.Println(jerr)
-		http.Error(w, jerr.Error(), http.StatusBadRequest)
+		writeError(w, jerr, http.StatusBadRequest)
+		return
+	}
+	if req.Reviewed == nil {
+		jsonError(w, "reviewed must be true or false", http.StatusBadRequest)
 		return
 	}
-	t.ID = vars["txid"]
+	t := &etx.Transaction{ID: vars["txid"], TenantID: tenantID(r), Reviewed: *req.Reviewed}
 	log.Printf("txid=%s", t.ID)
-	terr := t.SetReviewed()
+	terr := t.SetReviewed(r.Context())
 	if terr != nil {
 		log.Println(terr)
-		http.Error(w, terr.Error(), http.StatusBadRequest)
+		writeError(w, terr, errorStatus(terr))
 		return
 	}
-	etx.DB.Find(t, &etx.Transaction{ID: t.ID})
-	t.HttpJSON(w)
+	etx.DB.WithContext(r.Context()).Scopes(etx.TenantScope(t.TenantID)).Find(t, "id = ?", t.ID)
+	writeTransaction(w, t, http.StatusOK)
 }
 
-func Paginate(r *http.Request) func(db *gorm.DB) *gorm.DB {
-	return func(db *gorm.DB) *gorm.DB {
-		page, _ := strconv.Atoi(r.FormValue("page"))
-		if page == 0 {
-			page = 1
+// HandleStopMonitoring is an HTTP handler to stop monitoring a
+// transaction by txid while keeping its record
+func HandleStopMonitoring(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	log.WithFields(log.Fields{
+		"action": "HandleStopMonitoring",
+		"txid":   vars["txid"],
+	}).Println("Stop Monitoring Request")
+	t := &etx.Transaction{ID: vars["txid"], TenantID: tenantID(r)}
+	serr := t.StopMonitoring(r.Context())
+	if serr != nil {
+		log.Printf("error %v", serr)
+		writeError(w, serr, errorStatus(serr))
+		return
+	}
+	etx.DB.WithContext(r.Context()).Scopes(etx.TenantScope(t.TenantID)).Find(t, "id = ?", t.ID)
+	writeTransaction(w, t, http.StatusOK)
+}
+
+// HandleRequeueTransaction is an HTTP handler to resume monitoring a
+// transaction by txid, such as one which failed because of a transient issue.
+// Transactions which reverted on-chain are only requeued with force=true
+func HandleRequeueTransaction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	log.WithFields(log.Fields{
+		"action": "HandleRequeueTransaction",
+		"txid":   vars["txid"],
+	}).Println("Requeue Transaction Request")
+	t := &etx.Transaction{ID: vars["txid"], TenantID: tenantID(r)}
+	qerr := t.Requeue(r.Context(), r.FormValue("force") == "true")
+	if qerr != nil {
+		log.Printf("error %v", qerr)
+		writeError(w, qerr, errorStatus(qerr))
+		return
+	}
+	writeTransaction(w, t, http.StatusOK)
+}
+
+// HandleMergeMetadata is an HTTP handler to merge the metadata in
+// the request body into the metadata of a transaction by txid
+func HandleMergeMetadata(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	log.WithFields(log.Fields{
+		"action": "HandleMergeMetadata",
+		"txid":   vars["txid"],
+	}).Println("Merge Metadata Request")
+	defer r.Body.Close()
+	bd, berr := ioutil.ReadAll(r.Body)
+	if berr != nil {
+		log.Printf("error %v", berr)
+		writeError(w, berr, bodyErrorStatus(berr))
+		return
+	}
+	m := etx.MetadataMap{}
+	jerr := json.Unmarshal(bd, &m)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusBadRequest)
+		return
+	}
+	t := &etx.Transaction{ID: vars["txid"], TenantID: tenantID(r)}
+	merr := t.MergeMetadata(r.Context(), m)
+	if merr != nil {
+		log.Printf("error %v", merr)
+		writeError(w, merr, errorStatus(merr))
+		return
+	}
+	jd, jerr := json.Marshal(t.Metadata)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jd))
+}
+
+// HandleReplaceTransaction is an HTTP handler to record that a transaction
+// by txid was replaced by the transaction in the request body, returning
+// the replacement transaction
+func HandleReplaceTransaction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	log.WithFields(log.Fields{
+		"action": "HandleReplaceTransaction",
+		"txid":   vars["txid"],
+	}).Println("Replace Transaction Request")
+	defer r.Body.Close()
+	bd, berr := ioutil.ReadAll(r.Body)
+	if berr != nil {
+		log.Printf("error %v", berr)
+		writeError(w, berr, bodyErrorStatus(berr))
+		return
+	}
+	nt := &etx.Transaction{}
+	jerr := decodeJSON(bd, nt)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusBadRequest)
+		return
+	}
+	if verr := etx.ValidateTxID(nt.ID); verr != nil {
+		log.Printf("error %v", verr)
+		writeError(w, verr, http.StatusBadRequest)
+		return
+	}
+	t := &etx.Transaction{ID: vars["txid"], TenantID: tenantID(r)}
+	nt, rerr := t.Replace(r.Context(), nt.ID)
+	if rerr != nil {
+		log.Printf("error %v", rerr)
+		writeError(w, rerr, errorStatus(rerr))
+		return
+	}
+	writeTransaction(w, nt, http.StatusCreated)
+}
+
+// HandleWatchAddress is an HTTP handler to register an address whose
+// outgoing transactions are added to the monitor
+func HandleWatchAddress(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"action": "HandleWatchAddress",
+	}).Println("Watch Address Request")
+	defer r.Body.Close()
+	bd, berr := ioutil.ReadAll(r.Body)
+	if berr != nil {
+		log.Printf("error %v", berr)
+		writeError(w, berr, bodyErrorStatus(berr))
+		return
+	}
+	req := struct {
+		Address    string `json:"address"`
+		Blockchain string `json:"blockchain"`
+		StartBlock uint64 `json:"startBlock"`
+	}{}
+	jerr := decodeJSON(bd, &req)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusBadRequest)
+		return
+	}
+	a := &etx.WatchedAddress{Address: req.Address, Blockchain: req.Blockchain, TenantID: tenantID(r)}
+	aerr := a.New(r.Context(), req.StartBlock)
+	if aerr != nil {
+		log.Printf("error %v", aerr)
+		writeError(w, aerr, errorStatus(aerr))
+		return
+	}
+	jd, jerr := json.Marshal(a)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, string(jd))
+}
+
+// HandleGetTransaction is an HTTP handler to retrieve a single
+// transaction by txid
+func HandleGetTransaction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	log.WithFields(log.Fields{
+		"action": "HandleGetTransaction",
+		"txid":   vars["txid"],
+	}).Println("Get Transaction Request")
+	t := &etx.Transaction{}
+	res := etx.ReadDB.WithContext(r.Context()).Scopes(etx.TenantScope(tenantID(r))).Find(t, "id = ?", vars["txid"])
+	if res.Error != nil {
+		log.Printf("error %v", res.Error)
+		writeError(w, res.Error, http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		writeError(w, etx.ErrNotFound, http.StatusNotFound)
+		return
+	}
+	writeTransaction(w, t, http.StatusOK)
+}
+
+// HandleGetHistory is an HTTP handler to retrieve the state
+// changes of a transaction by txid
+func HandleGetHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	log.WithFields(log.Fields{
+		"action": "HandleGetHistory",
+		"txid":   vars["txid"],
+	}).Println("Get History Request")
+	t := &etx.Transaction{ID: vars["txid"], TenantID: tenantID(r)}
+	events, herr := t.History(r.Context())
+	if herr != nil {
+		log.Printf("error %v", herr)
+		writeError(w, herr, errorStatus(herr))
+		return
+	}
+	jd, jerr := json.Marshal(events)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jd))
+}
+
+// HandleDeleteTransaction is an HTTP handler to remove a transaction
+// from the monitor by txid
+func HandleDeleteTransaction(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	log.WithFields(log.Fields{
+		"action": "HandleDeleteTransaction",
+		"txid":   vars["txid"],
+	}).Println("Delete Transaction Request")
+	t := &etx.Transaction{ID: vars["txid"], TenantID: tenantID(r)}
+	derr := t.Delete(r.Context())
+	if derr != nil {
+		log.Printf("error %v", derr)
+		writeError(w, derr, errorStatus(derr))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeJSON decodes a request body into v, rejecting fields v does not
+// define so that misspelled fields are not silently ignored
+func decodeJSON(bd []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(bd))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err == io.EOF {
+		return errors.New("request body is empty")
+	} else if err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("request body must contain a single JSON object")
+	}
+	return checkFieldCase(bd, reflect.TypeOf(v))
+}
+
+// checkFieldCase rejects the fields of a JSON object, or of an array of
+// objects, which only match a field of t case-insensitively, such as txId
+// for txid, which encoding/json would otherwise accept
+func checkFieldCase(bd []byte, t reflect.Type) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		var items []json.RawMessage
+		if err := json.Unmarshal(bd, &items); err != nil {
+			return nil
 		}
+		for _, item := range items {
+			if err := checkFieldCase(item, t.Elem()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(bd, &fields); err != nil {
+			return nil
+		}
+		names := jsonFieldNames(t)
+		for k := range fields {
+			if !names[k] {
+				return fmt.Errorf("json: unknown field %q", k)
+			}
+		}
+	}
+	return nil
+}
 
-		pageSize, _ := strconv.Atoi(r.FormValue("pageSize"))
-		switch {
-		case pageSize > 100:
-			pageSize = 100
-		case pageSize <= 0:
-			pageSize = 10
+// jsonFieldNames returns the JSON names of the fields of a struct, including
+// the fields of embedded structs
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || f.PkgPath != "" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for n := range jsonFieldNames(f.Type) {
+				names[n] = true
+			}
+			continue
 		}
+		if name == "" {
+			name = f.Name
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// pageSizeEnv parses a page size env var, returning def if it is unset or invalid
+func pageSizeEnv(k string, def int) int {
+	ps, perr := strconv.Atoi(os.Getenv(k))
+	if perr != nil || ps <= 0 {
+		return def
+	}
+	return ps
+}
+
+// maxPageSize returns the largest page size which can be requested,
+// configured with MAX_PAGE_SIZE and defaulting to 100
+func maxPageSize() int {
+	return pageSizeEnv("MAX_PAGE_SIZE", 100)
+}
+
+// defaultPageSize returns the page size used when none is requested, configured
+// with DEFAULT_PAGE_SIZE and defaulting to 10. It is never more than maxPageSize
+func defaultPageSize() int {
+	ps := pageSizeEnv("DEFAULT_PAGE_SIZE", 10)
+	if max := maxPageSize(); ps > max {
+		return max
+	}
+	return ps
+}
+
+// pageParams returns the requested page and page size, applying
+// the default and maximum page size
+func pageParams(r *http.Request) (int, int) {
+	page, _ := strconv.Atoi(r.FormValue("page"))
+	if page == 0 {
+		page = 1
+	}
+
+	pageSize, _ := strconv.Atoi(r.FormValue("pageSize"))
+	switch max := maxPageSize(); {
+	case pageSize > max:
+		pageSize = max
+	case pageSize <= 0:
+		pageSize = defaultPageSize()
+	}
+	return page, pageSize
+}
 
+func Paginate(r *http.Request) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		page, pageSize := pageParams(r)
 		offset := (page - 1) * pageSize
 		return db.Offset(offset).Limit(pageSize)
 	}
 }
 
+// encodeCursor returns the cursor of a transaction for keyset pagination,
+// which is its creation time and ID
+func encodeCursor(t etx.Transaction) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + t.ID))
+}
+
+// CursorPaginate returns a scope selecting a page of transactions after the
+// after query parameter, a cursor returned by encodeCursor, ordered by creation
+// time and ID. An empty cursor selects the first page. One more transaction than
+// the page size is selected, so that the caller can tell if there is a next page
+func CursorPaginate(r *http.Request) (func(db *gorm.DB) *gorm.DB, error) {
+	if sortBy := r.FormValue("sortBy"); sortBy != "" && sortBy != "created_at" {
+		return nil, fmt.Errorf("invalid sortBy %q, cursor pagination is sorted by created_at", sortBy)
+	}
+	var desc bool
+	switch r.FormValue("order") {
+	case "", "desc":
+		desc = true
+	case "asc":
+		desc = false
+	default:
+		return nil, fmt.Errorf("invalid order %q, must be asc or desc", r.FormValue("order"))
+	}
+	var createdAt time.Time
+	var id string
+	if after := r.FormValue("after"); after != "" {
+		cd, err := base64.RawURLEncoding.DecodeString(after)
+		ss := strings.SplitN(string(cd), "|", 2)
+		if err != nil || len(ss) != 2 {
+			return nil, fmt.Errorf("invalid cursor %q", after)
+		}
+		if createdAt, err = time.Parse(time.RFC3339Nano, ss[0]); err != nil {
+			return nil, fmt.Errorf("invalid cursor %q", after)
+		}
+		id = ss[1]
+	}
+	_, pageSize := pageParams(r)
+	return func(db *gorm.DB) *gorm.DB {
+		cmp := ">"
+		if desc {
+			cmp = "<"
+		}
+		if id != "" {
+			db = db.Where("created_at "+cmp+" ? OR (created_at = ? AND id "+cmp+" ?)", createdAt, createdAt, id)
+		}
+		return db.Order(clause.OrderByColumn{Column: clause.Column{Name: "created_at"}, Desc: desc}).
+			Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}, Desc: desc}).
+			Limit(pageSize + 1)
+	}, nil
+}
+
+// StatusFilter returns a scope selecting transactions in the provided status.
+// Unlike the JSON filter body, which cannot express false booleans, each
+// status maps to an explicit where clause
+func StatusFilter(status string) (func(db *gorm.DB) *gorm.DB, error) {
+	var query string
+	switch status {
+	case "":
+		return func(db *gorm.DB) *gorm.DB { return db }, nil
+	case "pending":
+		query = "pending = true"
+	case "success":
+		query = "success = true"
+	case "failed":
+		query = "success = false AND monitoring = false"
+	case "monitoring":
+		query = "monitoring = true"
+	default:
+		return nil, fmt.Errorf("invalid status %q, must be one of pending, success, failed, monitoring", status)
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(query)
+	}, nil
+}
+
+// CreatedFilter returns a scope selecting transactions created after and before
+// the provided RFC3339 timestamps. Either bound may be empty
+func CreatedFilter(after, before string) (func(db *gorm.DB) *gorm.DB, error) {
+	var bounds []func(db *gorm.DB) *gorm.DB
+	if after != "" {
+		a, err := time.Parse(time.RFC3339, after)
+		if err != nil {
+			return nil, fmt.Errorf("invalid createdAfter %q, must be an RFC3339 timestamp", after)
+		}
+		bounds = append(bounds, func(db *gorm.DB) *gorm.DB { return db.Where("created_at > ?", a) })
+	}
+	if before != "" {
+		b, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			return nil, fmt.Errorf("invalid createdBefore %q, must be an RFC3339 timestamp", before)
+		}
+		bounds = append(bounds, func(db *gorm.DB) *gorm.DB { return db.Where("created_at < ?", b) })
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(bounds...)
+	}, nil
+}
+
+// MetadataFilter returns a scope selecting transactions whose metadata contains
+// every metadata.<key>=<value> query parameter. The filter uses JSONB containment
+// and is only supported by the postgres driver
+func MetadataFilter(r *http.Request) (func(db *gorm.DB) *gorm.DB, error) {
+	m := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if strings.HasPrefix(k, "metadata.") && len(v) > 0 {
+			m[strings.TrimPrefix(k, "metadata.")] = v[0]
+		}
+	}
+	if len(m) == 0 {
+		return func(db *gorm.DB) *gorm.DB { return db }, nil
+	}
+	if etx.DB.Dialector.Name() != "postgres" {
+		return nil, errors.New("metadata filters require the postgres driver")
+	}
+	jd, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("convert_from(metadata, 'UTF8')::jsonb @> ?::jsonb", string(jd))
+	}, nil
+}
+
+// sortColumns are the columns transactions can be sorted by
+var sortColumns = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"checks":     true,
+}
+
+// SortOrder returns a scope ordering transactions by an allowed column,
+// defaulting to the newest transactions first
+func SortOrder(sortBy, order string) (func(db *gorm.DB) *gorm.DB, error) {
+	if sortBy == "" {
+		sortBy = "created_at"
+	}
+	if !sortColumns[sortBy] {
+		return nil, fmt.Errorf("invalid sortBy %q, must be one of created_at, updated_at, checks", sortBy)
+	}
+	var desc bool
+	switch order {
+	case "", "desc":
+		desc = true
+	case "asc":
+		desc = false
+	default:
+		return nil, fmt.Errorf("invalid order %q, must be asc or desc", order)
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Order(clause.OrderByColumn{Column: clause.Column{Name: sortBy}, Desc: desc})
+	}, nil
+}
+
+// TransactionsPage is a page of transactions along with the
+// pagination metadata needed to request further pages
+type TransactionsPage struct {
+	Total      int64             `json:"total"`
+	Page       int               `json:"page,omitempty"`
+	PageSize   int               `json:"pageSize"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+	Data       []etx.Transaction `json:"data"`
+}
+
+// TransactionCounts is the number of transactions in each status. The statuses
+// overlap, for example a pending transaction is also monitoring
+type TransactionCounts struct {
+	Blockchain string `json:"blockchain,omitempty"`
+	Total      int64  `json:"total"`
+	Monitoring int64  `json:"monitoring"`
+	Pending    int64  `json:"pending"`
+	Success    int64  `json:"success"`
+	Failed     int64  `json:"failed"`
+	Reviewed   int64  `json:"reviewed"`
+}
+
+// transactionCountsSelect counts each status with the same conditions as StatusFilter.
+// SUM is NULL when there are no transactions
+const transactionCountsSelect = `count(*) AS total,
+	COALESCE(SUM(CASE WHEN monitoring = true THEN 1 ELSE 0 END), 0) AS monitoring,
+	COALESCE(SUM(CASE WHEN pending = true THEN 1 ELSE 0 END), 0) AS pending,
+	COALESCE(SUM(CASE WHEN success = true THEN 1 ELSE 0 END), 0) AS success,
+	COALESCE(SUM(CASE WHEN success = false AND monitoring = false THEN 1 ELSE 0 END), 0) AS failed,
+	COALESCE(SUM(CASE WHEN reviewed = true THEN 1 ELSE 0 END), 0) AS reviewed`
+
+// HandleCountTransactions is an HTTP handler returning the number of transactions
+// in each status, or a list of counts for each blockchain with groupBy=blockchain
+func HandleCountTransactions(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"action": "HandleCountTransactions",
+	}).Println("Count Transactions Request")
+	q := etx.DB.WithContext(r.Context()).Model(&etx.Transaction{}).Scopes(etx.TenantScope(tenantID(r)))
+	var resp interface{}
+	switch r.FormValue("groupBy") {
+	case "":
+		counts := TransactionCounts{}
+		if err := q.Select(transactionCountsSelect).Scan(&counts).Error; err != nil {
+			log.Printf("error %v", err)
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		resp = counts
+	case "blockchain":
+		counts := []TransactionCounts{}
+		if err := q.Select("blockchain, " + transactionCountsSelect).Group("blockchain").Order("blockchain").Scan(&counts).Error; err != nil {
+			log.Printf("error %v", err)
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		resp = counts
+	default:
+		jsonError(w, "invalid groupBy, must be blockchain", http.StatusBadRequest)
+		return
+	}
+	jd, jerr := json.Marshal(resp)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jd))
+}
+
+// BlockchainUsage is the number of transactions stored for a blockchain, and
+// whether the blockchain has configured clients
+type BlockchainUsage struct {
+	Blockchain string `json:"blockchain"`
+	Total      int64  `json:"total"`
+	Monitoring int64  `json:"monitoring"`
+	Configured bool   `json:"configured"`
+}
+
+// HandleGetTransactionBlockchains is an HTTP handler listing the distinct blockchains
+// of the stored transactions with their counts. Unlike /blockchains, which lists the
+// configured clients, it includes transactions on blockchains which are not configured
+func HandleGetTransactionBlockchains(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"action": "HandleGetTransactionBlockchains",
+	}).Println("Get Transaction Blockchains Request")
+	q := etx.ReadDB.WithContext(r.Context()).Model(&etx.Transaction{}).Scopes(etx.TenantScope(tenantID(r)))
+	usage := []BlockchainUsage{}
+	err := q.Select("blockchain, count(*) AS total, " +
+		"COALESCE(SUM(CASE WHEN monitoring = true THEN 1 ELSE 0 END), 0) AS monitoring").
+		Group("blockchain").Order("blockchain").Scan(&usage).Error
+	if err != nil {
+		log.Printf("error %v", err)
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	for i := range usage {
+		_, usage[i].Configured = etx.Clients[usage[i].Blockchain]
+	}
+	jd, jerr := json.Marshal(usage)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jd))
+}
+
 // HandleGetTransactions is an HTTP handler to retrieve transaction
 // details from the database
 func HandleGetTransactions(w http.ResponseWriter, r *http.Request) {
 	log.WithFields(log.Fields{
-		"action": "HandleGetTransaction",
-	}).Println("Get Transaction Request")
+		"action": "HandleGetTransactions",
+	}).Println("Get Transactions Request")
 	defer r.Body.Close()
 	t := &etx.Transaction{}
 	bd, berr := ioutil.ReadAll(r.Body)
 	if berr != nil {
 		log.Printf("error %v", berr)
-		http.Error(w, berr.Error(), http.StatusBadRequest)
+		writeError(w, berr, bodyErrorStatus(berr))
+		return
+	}
+	jerr := decodeJSON(bd, &t)
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusBadRequest)
+		return
+	}
+	status, serr := StatusFilter(r.FormValue("status"))
+	if serr != nil {
+		log.Printf("error %v", serr)
+		writeError(w, serr, http.StatusBadRequest)
+		return
+	}
+	order, oerr := SortOrder(r.FormValue("sortBy"), r.FormValue("order"))
+	if oerr != nil {
+		log.Printf("error %v", oerr)
+		writeError(w, oerr, http.StatusBadRequest)
+		return
+	}
+	metadata, merr := MetadataFilter(r)
+	if merr != nil {
+		log.Printf("error %v", merr)
+		writeError(w, merr, http.StatusBadRequest)
+		return
+	}
+	created, cerr := CreatedFilter(r.FormValue("createdAfter"), r.FormValue("createdBefore"))
+	if cerr != nil {
+		log.Printf("error %v", cerr)
+		writeError(w, cerr, http.StatusBadRequest)
 		return
 	}
-	jerr := json.Unmarshal(bd, &t)
+	// the tenant of the request is applied as a scope, as the empty tenant is
+	// skipped by struct conditions
+	t.TenantID = ""
+	tenant := etx.TenantScope(tenantID(r))
+	page := Paginate(r)
+	_, cursor := r.URL.Query()["after"]
+	if cursor {
+		if r.FormValue("envelope") == "false" {
+			jsonError(w, "cursor pagination requires the envelope", http.StatusBadRequest)
+			return
+		}
+		var perr error
+		if page, perr = CursorPaginate(r); perr != nil {
+			log.Printf("error %v", perr)
+			writeError(w, perr, http.StatusBadRequest)
+			return
+		}
+		// the cursor scope orders by created_at and id
+		order = func(db *gorm.DB) *gorm.DB { return db }
+	}
+	var ot []etx.Transaction
+	if err := etx.ReadDB.WithContext(r.Context()).Scopes(tenant, status, metadata, created, order, page).Find(&ot, t).Error; err != nil {
+		log.Printf("error %v", err)
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	var resp interface{} = ot
+	if r.FormValue("envelope") != "false" {
+		var total int64
+		if err := etx.ReadDB.WithContext(r.Context()).Model(&etx.Transaction{}).Scopes(tenant, status, metadata, created).Where(t).Count(&total).Error; err != nil {
+			log.Printf("error %v", err)
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		pageNum, pageSize := pageParams(r)
+		tp := &TransactionsPage{
+			Total:    total,
+			Page:     pageNum,
+			PageSize: pageSize,
+			Data:     ot,
+		}
+		if cursor {
+			tp.Page = 0
+			if len(ot) > pageSize {
+				tp.Data = ot[:pageSize]
+				tp.NextCursor = encodeCursor(ot[pageSize-1])
+			}
+		}
+		resp = tp
+	}
+	jd, jerr := json.Marshal(resp)
 	if jerr != nil {
 		log.Printf("error %v", jerr)
-		http.Error(w, jerr.Error(), http.StatusBadRequest)
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jd))
+}
+
+// HandleGetPendingReview is an HTTP handler to list the resolved transactions
+// which have not been reviewed, oldest first, as a queue for human review
+func HandleGetPendingReview(w http.ResponseWriter, r *http.Request) {
+	log.WithFields(log.Fields{
+		"action": "HandleGetPendingReview",
+	}).Println("Get Pending Review Request")
+	tenant := etx.TenantScope(tenantID(r))
+	unreviewed := func(db *gorm.DB) *gorm.DB {
+		return db.Where("monitoring = ? AND reviewed = ?", false, false).Scopes(tenant)
+	}
+	var total int64
+	if err := etx.ReadDB.WithContext(r.Context()).Model(&etx.Transaction{}).Scopes(unreviewed).Count(&total).Error; err != nil {
+		log.Printf("error %v", err)
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 	var ot []etx.Transaction
-	etx.DB.Scopes(Paginate(r)).Find(&ot, t)
-	jd, jerr := json.Marshal(ot)
+	if err := etx.ReadDB.WithContext(r.Context()).Scopes(unreviewed, Paginate(r)).Order("COALESCE(resolved_at, updated_at) asc").Find(&ot).Error; err != nil {
+		log.Printf("error %v", err)
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	page, pageSize := pageParams(r)
+	jd, jerr := json.Marshal(&TransactionsPage{
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+		Data:     ot,
+	})
 	if jerr != nil {
 		log.Printf("error %v", jerr)
-		http.Error(w, jerr.Error(), http.StatusBadRequest)
+		writeError(w, jerr, http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprint(w, string(jd))
 }
 
-func init() {
+// parseChainIDs parses ETH_CHAIN_IDS in the form of '<name>=<chain id>,<name>=<chain id>'
+func parseChainIDs(s string) (map[string]*big.Int, error) {
+	ids := make(map[string]*big.Int)
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		ss := strings.SplitN(e, "=", 2)
+		if len(ss) != 2 {
+			return nil, errors.New("ETH_CHAIN_IDS must be in the form of '<name>=<chain id>'")
+		}
+		id, ok := new(big.Int).SetString(strings.TrimSpace(ss[1]), 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid chain id %q for %s", ss[1], ss[0])
+		}
+		ids[strings.TrimSpace(ss[0])] = id
+	}
+	return ids, nil
+}
+
+// parseEndpoints parses ETH_ENDPOINTS in the form of
+// '<name>=<endpoint>[;<endpoint>...],<name>=<endpoint>'. Failover endpoints for
+// a name may be separated by either a semicolon or a comma
+func parseEndpoints(s string) (map[string][]string, error) {
+	endpoints := make(map[string][]string)
+	var name string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		ss := strings.SplitN(e, "=", 2)
+		if len(ss) == 2 && !strings.Contains(ss[0], "://") {
+			name = strings.TrimSpace(ss[0])
+			e = ss[1]
+		}
+		if name == "" {
+			return nil, errors.New("ETH_ENDPOINTS must be in the form of '<name>=<endpoint>'")
+		}
+		for _, ep := range strings.Split(e, ";") {
+			if ep = strings.TrimSpace(ep); ep != "" {
+				endpoints[name] = append(endpoints[name], ep)
+			}
+		}
+	}
+	return endpoints, nil
+}
+
+// splitEndpointHeaders splits the HTTP headers from an endpoint in the form of
+// '<endpoint>|<header>:<value>[|<header>:<value>...]', such as the API key
+// header of a provider
+func splitEndpointHeaders(e string) (string, http.Header, error) {
+	ss := strings.Split(e, "|")
+	headers := make(http.Header)
+	for _, h := range ss[1:] {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return "", nil, fmt.Errorf("invalid endpoint header %q, must be in the form of '<header>:<value>'", h)
+		}
+		headers.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return strings.TrimSpace(ss[0]), headers, nil
+}
+
+// dbDialector builds the gorm dialector for the database selected with
+// DB_DRIVER (postgres, mysql or sqlite), defaulting to postgres. For
+// postgres, DB_SSLMODE (default disable) and DB_SSLROOTCERT configure TLS.
+// For sqlite, DB_NAME is the path to the database file. The host of postgres
+// and mysql databases is provided, so that a read replica can be opened
+func dbDialector(host string) (gorm.Dialector, error) {
+	switch os.Getenv("DB_DRIVER") {
+	case "", "postgres":
+		sslmode := os.Getenv("DB_SSLMODE")
+		if sslmode == "" {
+			sslmode = "disable"
+		}
+		dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
+			host,
+			os.Getenv("DB_PORT"),
+			os.Getenv("DB_USER"),
+			os.Getenv("DB_NAME"),
+			os.Getenv("DB_PASSWORD"),
+			sslmode,
+		)
+		if rc := os.Getenv("DB_SSLROOTCERT"); rc != "" {
+			dsn += " sslrootcert=" + rc
+		}
+		return postgres.Open(dsn), nil
+	case "mysql":
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=UTC",
+			os.Getenv("DB_USER"),
+			os.Getenv("DB_PASSWORD"),
+			host,
+			os.Getenv("DB_PORT"),
+			os.Getenv("DB_NAME"),
+		)
+		return mysql.Open(dsn), nil
+	case "sqlite":
+		return sqlite.Open(os.Getenv("DB_NAME")), nil
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q, must be one of postgres, mysql, sqlite", os.Getenv("DB_DRIVER"))
+	}
+}
+
+// openDB opens the database on the provided host and configures its
+// connection pool
+func openDB(host string) (*gorm.DB, error) {
+	dialector, err := dbDialector(host)
+	if err != nil {
+		return nil, err
+	}
+	// DB_TABLE_PREFIX prefixes every table name, such as txwatch_transactions,
+	// for databases shared with other applications
+	db, err := gorm.Open(dialector, &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{
+			TablePrefix: os.Getenv("DB_TABLE_PREFIX"),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = configurePool(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// connectAttempts returns the number of times connecting to a dependency at
+// startup is attempted, configured with the provided env var and defaulting to 5
+func connectAttempts(k string) int {
+	ca, cerr := strconv.Atoi(os.Getenv(k))
+	if cerr != nil || ca < 1 {
+		return 5
+	}
+	return ca
+}
+
+// retryConnect calls connect up to attempts times until it succeeds, waiting one
+// second before the first retry and doubling the wait up to 30 seconds, so that
+// dependencies which start after txwatch can be waited for
+func retryConnect(name string, attempts int, connect func() error) error {
+	backoff := time.Second
 	var err error
+	for i := 1; ; i++ {
+		if err = connect(); err == nil || i >= attempts {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"action": "retryConnect",
+		}).Warnf("%s: attempt %d/%d failed, retrying in %s: %v", name, i, attempts, backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > time.Second*30 {
+			backoff = time.Second * 30
+		}
+	}
+}
+
+// configurePool applies the DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and
+// DB_CONN_MAX_LIFETIME (seconds) settings to the database connection pool.
+// By default up to MONITOR_WORKERS + 10 connections are opened, leaving
+// room for API requests alongside the monitor workers
+func configurePool(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	workers := etx.MonitorWorkersMax()
+	maxOpen, merr := strconv.Atoi(os.Getenv("DB_MAX_OPEN_CONNS"))
+	if merr != nil || maxOpen <= 0 {
+		maxOpen = workers + 10
+	}
+	maxIdle, ierr := strconv.Atoi(os.Getenv("DB_MAX_IDLE_CONNS"))
+	if ierr != nil || maxIdle < 0 {
+		maxIdle = workers
+	}
+	lifetime, lerr := strconv.Atoi(os.Getenv("DB_CONN_MAX_LIFETIME"))
+	if lerr != nil || lifetime < 0 {
+		lifetime = 0
+	}
+	log.Printf("database pool: maxOpen=%d maxIdle=%d maxLifetime=%ds", maxOpen, maxIdle, lifetime)
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetConnMaxLifetime(time.Second * time.Duration(lifetime))
+	return nil
+}
+
+// redactHook masks the URLs and DSN passwords in log messages, which
+// include the errors of RPC, callback and database connections
+type redactHook struct{}
+
+func (redactHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (redactHook) Fire(e *log.Entry) error {
+	e.Message = etx.Redact(e.Message)
+	return nil
+}
+
+// setup configures logging and connects to the database and blockchain endpoints.
+// It is called from main rather than init so that tests do not connect
+func setup() {
+	if os.Getenv("LOG_FORMAT") == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+	if ll := os.Getenv("LOG_LEVEL"); ll != "" {
+		level, lerr := log.ParseLevel(ll)
+		if lerr != nil {
+			log.Fatal(lerr)
+		}
+		log.SetLevel(level)
+	}
+	log.AddHook(redactHook{})
 	log.Printf("connecting to database")
-	dsn := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_NAME"),
-		os.Getenv("DB_PASSWORD"),
-	)
-	etx.DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	dbAttempts := connectAttempts("DB_CONNECT_ATTEMPTS")
+	err := retryConnect("database", dbAttempts, func() (err error) {
+		etx.DB, err = openDB(os.Getenv("DB_HOST"))
+		return err
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
-	etx.DB.AutoMigrate(&etx.Transaction{})
-	log.Printf("connecting to ethereum: %s\n", os.Getenv("ETH_ENDPOINT"))
-	for _, e := range strings.Split(os.Getenv("ETH_ENDPOINTS"), ",") {
-		e = strings.TrimSpace(e)
-		ss := strings.Split(e, "=")
-		if len(ss) != 2 {
-			log.Fatal("ETH_ENDPOINTS must be in the form of '<name>=<endpoint>'")
-		}
-		name := strings.TrimSpace(ss[0])
-		endpoint := strings.TrimSpace(ss[1])
-		log.Printf("connecting to ethereum: client=%s host=%s", name, endpoint)
-		var c *ethclient.Client
-		c, err = ethclient.Dial(endpoint)
+	etx.ReadDB = etx.DB
+	if rh := os.Getenv("DB_READ_HOST"); rh != "" {
+		log.Printf("connecting to read replica: host=%s", rh)
+		err = retryConnect("read replica", dbAttempts, func() (err error) {
+			etx.ReadDB, err = openDB(rh)
+			return err
+		})
 		if err != nil {
-			log.Fatal("ethclient error", err)
+			log.Fatal(err)
 		}
-		etx.Clients[name] = c
 	}
+	if err = etx.Migrate(etx.DB); err != nil {
+		log.Fatal(err)
+	}
+	endpoints, err := parseEndpoints(os.Getenv("ETH_ENDPOINTS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	connected := 0
+	for name, eps := range endpoints {
+		// the blockchain stays configured if none of its endpoints connect,
+		// so that its transactions are retried rather than stopped
+		etx.AddBlockchain(name)
+		for _, ep := range eps {
+			endpoint, headers, herr := splitEndpointHeaders(ep)
+			if herr != nil {
+				log.Fatal(herr)
+			}
+			log.Printf("connecting to ethereum: client=%s host=%s headers=%d", name, etx.RedactURL(endpoint), len(headers))
+			var c *rpc.Client
+			err = retryConnect("ethereum client "+name, connectAttempts("ETH_DIAL_ATTEMPTS"), func() (err error) {
+				c, err = rpc.Dial(endpoint)
+				return err
+			})
+			if err != nil {
+				log.Errorf("ethclient error, skipping endpoint: client=%s host=%s: %s", name, etx.RedactURL(endpoint), etx.Redact(err.Error()))
+				continue
+			}
+			if len(headers) > 0 && !strings.HasPrefix(endpoint, "http") {
+				log.Warnf("headers are only sent to http endpoints: client=%s host=%s", name, etx.RedactURL(endpoint))
+			}
+			for k := range headers {
+				c.SetHeader(k, headers.Get(k))
+			}
+			etx.AddBlockchainClient(name, c)
+			connected++
+		}
+	}
+	if len(endpoints) > 0 && connected == 0 {
+		log.Fatal("ethclient error: no endpoint connected")
+	}
+	chainIDs, err := parseChainIDs(os.Getenv("ETH_CHAIN_IDS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = etx.VerifyChainIDs(context.Background(), chainIDs); err != nil {
+		log.Fatal(err)
+	}
+	etx.CacheChainIDs(context.Background())
+	if err = etx.ResumeConfiguredBlockchains(context.Background()); err != nil {
+		log.Printf("error %v", err)
+	}
+	etx.ConfigureChecksThresholds()
+	etx.ConfigureNotifiers()
 	go etx.Healthchecker()
 }
 
+// Version and Commit identify the build, and are set with
+// -ldflags "-X main.Version=<version> -X main.Commit=<commit>"
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)
+
+// Info is the build and runtime configuration returned by /status/info.
+// It must not include secrets such as endpoints or credentials
+type Info struct {
+	Version           string   `json:"version"`
+	Commit            string   `json:"commit"`
+	GoVersion         string   `json:"goVersion"`
+	Blockchains       []string `json:"blockchains"`
+	MonitorWorkersMin int      `json:"monitorWorkersMin"`
+	MonitorWorkersMax int      `json:"monitorWorkersMax"`
+	ChecksTimer       int      `json:"checksTimer"`
+}
+
+// HandleInfo is an HTTP handler returning the build version and the
+// runtime configuration of the process
+func HandleInfo(w http.ResponseWriter, r *http.Request) {
+	jd, jerr := json.Marshal(&Info{
+		Version:           Version,
+		Commit:            Commit,
+		GoVersion:         runtime.Version(),
+		Blockchains:       etx.BlockchainNames(),
+		MonitorWorkersMin: etx.MonitorWorkersMin(),
+		MonitorWorkersMax: etx.MonitorWorkersMax(),
+		ChecksTimer:       int(etx.ChecksTimer().Seconds()),
+	})
+	if jerr != nil {
+		log.Printf("error %v", jerr)
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jd))
+}
+
+// HandleLiveness is an HTTP handler which reports that the process is running
+func HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "alive")
+}
+
+// ComponentHealth is the health of a single dependency
+type ComponentHealth struct {
+	Healthy bool   `json:"healthy"`
+	ChainID string `json:"chainId,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthStatus is the health of the database and each blockchain client
+type HealthStatus struct {
+	Healthy     bool                       `json:"healthy"`
+	Database    ComponentHealth            `json:"database"`
+	Blockchains map[string]ComponentHealth `json:"blockchains"`
+}
+
+// HandleHealthCheck is an HTTP handler reporting the health of the database and
+// each blockchain client. It fails only when the database is unreachable, unless
+// strict=true is set in which case any unhealthy blockchain also fails the check
 func HandleHealthCheck(w http.ResponseWriter, r *http.Request) {
-	for _, c := range etx.Clients {
-		_, err := c.ChainID(context.Background())
+	hs := HealthStatus{
+		Healthy:     true,
+		Database:    ComponentHealth{Healthy: true},
+		Blockchains: make(map[string]ComponentHealth),
+	}
+	if err := etx.Healthcheck(); err != nil {
+		hs.Healthy = false
+		hs.Database = ComponentHealth{Error: err.Error()}
+	}
+	strict := r.FormValue("strict") == "true"
+	for _, name := range etx.BlockchainNames() {
+		id, err := etx.BlockchainChainID(r.Context(), name)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			hs.Blockchains[name] = ComponentHealth{Error: err.Error()}
+			if strict {
+				hs.Healthy = false
+			}
+			continue
+		}
+		hs.Blockchains[name] = ComponentHealth{Healthy: true, ChainID: id.String()}
+	}
+	jd, jerr := json.Marshal(hs)
+	if jerr != nil {
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !hs.Healthy {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	fmt.Fprint(w, string(jd))
+}
+
+// BlockchainInfo describes a configured blockchain
+type BlockchainInfo struct {
+	Name    string `json:"name"`
+	ChainID string `json:"chainId,omitempty"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HandleGetBlockchains is an HTTP handler listing the configured blockchains
+// with their chain ID and health. The chain ID of an unhealthy blockchain is
+// the one last reported, if any
+func HandleGetBlockchains(w http.ResponseWriter, r *http.Request) {
+	bs := []BlockchainInfo{}
+	for _, name := range etx.BlockchainNames() {
+		bi := BlockchainInfo{Name: name}
+		if _, err := etx.BlockchainChainID(r.Context(), name); err != nil {
+			bi.Error = err.Error()
+		} else {
+			bi.Healthy = true
+		}
+		if id := etx.CachedChainID(name); id != nil {
+			bi.ChainID = id.String()
 		}
+		bs = append(bs, bi)
+	}
+	jd, jerr := json.Marshal(bs)
+	if jerr != nil {
+		writeError(w, jerr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(jd))
+}
+
+// serverTimeout returns an HTTP server timeout configured in seconds with
+// the env var k, or def if it is not set. Zero disables the timeout
+func serverTimeout(k string, def time.Duration) time.Duration {
+	st, serr := strconv.Atoi(os.Getenv(k))
+	if serr != nil || st < 0 {
+		return def
+	}
+	return time.Second * time.Duration(st)
+}
+
+// listenPort returns the port the API listens on, configured with PORT
+// and defaulting to 8080
+func listenPort() (string, error) {
+	port := os.Getenv("PORT")
+	if port == "" {
+		return "8080", nil
 	}
-	fmt.Fprint(w, "healthy")
+	p, perr := strconv.Atoi(port)
+	if perr != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("invalid PORT %q, must be a number between 1 and 65535", port)
+	}
+	return port, nil
+}
+
+// routePrefix returns the path prefix of every route, configured with
+// ROUTE_PREFIX such as "/txwatch". Routes are not prefixed by default
+func routePrefix() string {
+	p := strings.Trim(os.Getenv("ROUTE_PREFIX"), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
 }
 
-func api() {
+// router returns the handler of the API routes and middlewares
+func router() http.Handler {
 	r := mux.NewRouter()
-	r.HandleFunc("/transaction", HandleNewTransaction).Methods("POST")
-	r.HandleFunc("/transaction/{txid}/reviewed", HandleSetReviewed).Methods("POST")
-	r.HandleFunc("/transactions", HandleGetTransactions).Methods("POST")
-	r.HandleFunc("/status/healthz", HandleHealthCheck).Methods("GET")
-	log.Printf("Listening on :%s\n", os.Getenv("PORT"))
-	http.ListenAndServe(":"+os.Getenv("PORT"), r)
+	r.Use(otelmux.Middleware("txwatch"))
+	r.Use(RateLimitMiddleware())
+	r.Use(AuthMiddleware)
+	r.Use(BodyLimitMiddleware())
+	r.Use(GzipMiddleware())
+	r.Use(TenantMiddleware)
+	routes := r
+	if prefix := routePrefix(); prefix != "" {
+		routes = r.PathPrefix(prefix).Subrouter()
+	}
+	routes.HandleFunc("/transaction", HandleNewTransaction).Methods("POST")
+	routes.HandleFunc("/transaction/validate", HandleValidateTransaction).Methods("POST")
+	routes.HandleFunc("/transaction/{txid}", HandleGetTransaction).Methods("GET")
+	routes.HandleFunc("/transaction/{txid}", HandleDeleteTransaction).Methods("DELETE")
+	routes.HandleFunc("/transaction/{txid}/reviewed", HandleSetReviewed).Methods("POST")
+	routes.HandleFunc("/transaction/{txid}/stop", HandleStopMonitoring).Methods("POST")
+	routes.HandleFunc("/transaction/{txid}/requeue", HandleRequeueTransaction).Methods("POST")
+	routes.HandleFunc("/transaction/{txid}/metadata", HandleMergeMetadata).Methods("PATCH")
+	routes.HandleFunc("/transaction/{txid}/replace", HandleReplaceTransaction).Methods("POST")
+	routes.HandleFunc("/transaction/{txid}/history", HandleGetHistory).Methods("GET")
+	routes.HandleFunc("/transactions", HandleGetTransactions).Methods("POST")
+	routes.HandleFunc("/transactions/bulk", HandleNewTransactions).Methods("POST")
+	routes.HandleFunc("/transactions/count", HandleCountTransactions).Methods("GET")
+	routes.HandleFunc("/transactions/pending-review", HandleGetPendingReview).Methods("GET")
+	routes.HandleFunc("/transactions/blockchains", HandleGetTransactionBlockchains).Methods("GET")
+	routes.HandleFunc("/address", HandleWatchAddress).Methods("POST")
+	routes.HandleFunc("/blockchains", HandleGetBlockchains).Methods("GET")
+	routes.HandleFunc("/transactions/stream", HandleTransactionStream).Methods("GET")
+	routes.HandleFunc("/transactions/events", HandleTransactionEvents).Methods("GET")
+	routes.HandleFunc("/status/healthz", HandleHealthCheck).Methods("GET")
+	routes.HandleFunc("/status/readyz", HandleHealthCheck).Methods("GET")
+	routes.HandleFunc("/status/livez", HandleLiveness).Methods("GET")
+	routes.HandleFunc("/status/info", HandleInfo).Methods("GET")
+	return LoggingHandler(CORSHandler(r))
+}
+
+func api() *http.Server {
+	port, err := listenPort()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the write timeout is disabled by default as it also bounds
+	// the lifetime of the streaming endpoints
+	srv := &http.Server{
+		Addr:         ":" + port,
+		Handler:      router(),
+		ReadTimeout:  serverTimeout("HTTP_READ_TIMEOUT", time.Second*30),
+		WriteTimeout: serverTimeout("HTTP_WRITE_TIMEOUT", 0),
+		IdleTimeout:  serverTimeout("HTTP_IDLE_TIMEOUT", time.Second*120),
+	}
+	log.Printf("Listening on :%s\n", port)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	return srv
 }
 
-func worker() {
+// worker checks the monitored transactions every CHECKS_TIMER seconds, or
+// more often if a blockchain has a shorter CHECK_INTERVAL_<name>, plus up to
+// CHECKS_JITTER percent, until ctx is cancelled. A check which is in progress
+// when ctx is cancelled is allowed to finish before worker returns
+func worker(ctx context.Context) {
 	log.WithFields(log.Fields{
 		"action": "worker",
 	}).Println("run")
-	ctx := context.Background()
-	ct, cerr := strconv.Atoi(os.Getenv("CHECKS_TIMER"))
-	if cerr != nil {
-		log.Fatal(cerr)
-	}
+	interval := etx.MinCheckInterval()
 	for {
-		etx.CheckMonitoredTransactions(ctx)
-		time.Sleep(time.Second * time.Duration(ct))
+		etx.CheckMonitoredTransactions(context.Background())
+		select {
+		case <-ctx.Done():
+			log.WithFields(log.Fields{
+				"action": "worker",
+			}).Println("stopped")
+			return
+		case <-time.After(etx.Jitter(interval)):
+		case <-etx.NewHeads:
+		}
 	}
 }
 
+// reconciler re-verifies recently resolved transactions for reorgs
+// every CHECKS_TIMER seconds when REORG_WINDOW_BLOCKS is set
+func reconciler(ctx context.Context) {
+	etx.ReorgReconciler(ctx, etx.ChecksTimer())
+}
+
+// addressWatcher scans the blocks for transactions from watched addresses
+// every CHECKS_TIMER seconds when ADDRESS_SCAN_BLOCKS is set
+func addressWatcher(ctx context.Context) {
+	etx.AddressWatcher(ctx, etx.ChecksTimer())
+}
+
 func main() {
-	go worker()
-	api()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	setup()
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	shutdownTracing, err := initTracing(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		worker(ctx)
+		close(done)
+	}()
+	go reconciler(ctx)
+	go addressWatcher(ctx)
+	go etx.Pruner(ctx, time.Hour)
+	etx.HeadSubscriber(ctx, time.Minute)
+	srv := api()
+	<-ctx.Done()
+	log.Println("shutting down")
+	sctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		log.Printf("error %v", err)
+	}
+	<-done
+	if err := shutdownTracing(sctx); err != nil {
+		log.Printf("error %v", err)
+	}
 }
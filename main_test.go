@@ -0,0 +1,847 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robertlestak/txwatch/internal/etx"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestAPI points etx at a new sqlite database with the eth and poly
+// blockchains configured, and returns the API handler
+func setupTestAPI(t *testing.T) http.Handler {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := etx.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	prevDB, prevReadDB := etx.DB, etx.ReadDB
+	etx.DB, etx.ReadDB = db, db
+	t.Cleanup(func() {
+		etx.DB, etx.ReadDB = prevDB, prevReadDB
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	etx.AddBlockchain("eth")
+	etx.AddBlockchain("poly")
+	return router()
+}
+
+// testTxID returns a valid transaction hash of the repeated hex digit c
+func testTxID(c string) string {
+	return "0x" + strings.Repeat(c, 64)
+}
+
+// doRequest sends a request to h with the provided body and headers
+func doRequest(h http.Handler, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// errorResponseCode decodes the code of an error response
+func errorResponseCode(t *testing.T, w *httptest.ResponseRecorder) ErrorCode {
+	t.Helper()
+	er := ErrorResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &er); err != nil {
+		t.Fatalf("decoding error response %q: %v", w.Body.String(), err)
+	}
+	return er.Code
+}
+
+func TestTenantIsolation(t *testing.T) {
+	h := setupTestAPI(t)
+	id := testTxID("a")
+	tenantA := map[string]string{tenantHeader: "a"}
+	w := doRequest(h, "POST", "/transaction", `{"txid":"`+id+`","blockchain":"eth"}`, tenantA)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", w.Code, w.Body.String())
+	}
+	others := map[string]map[string]string{
+		"other tenant": {tenantHeader: "b"},
+		"no tenant":    nil,
+	}
+	for name, headers := range others {
+		t.Run(name, func(t *testing.T) {
+			tests := []struct {
+				method, path, body string
+				status             int
+				hidden             string
+			}{
+				{"GET", "/transaction/" + id, "", http.StatusNotFound, ""},
+				{"GET", "/transaction/" + id + "/history", "", http.StatusNotFound, ""},
+				{"POST", "/transaction/" + id + "/stop", "", http.StatusNotFound, ""},
+				{"PATCH", "/transaction/" + id + "/metadata", `{"k":"v"}`, http.StatusNotFound, ""},
+				{"POST", "/transactions", `{}`, http.StatusOK, id},
+				{"GET", "/transactions/count", "", http.StatusOK, `"total":1`},
+				{"GET", "/transactions/blockchains", "", http.StatusOK, "eth"},
+				{"GET", "/transactions/pending-review", "", http.StatusOK, id},
+				{"DELETE", "/transaction/" + id, "", http.StatusNotFound, ""},
+			}
+			for _, tt := range tests {
+				w := doRequest(h, tt.method, tt.path, tt.body, headers)
+				if w.Code != tt.status {
+					t.Errorf("%s %s returned %d, want %d: %s", tt.method, tt.path, w.Code, tt.status, w.Body.String())
+				}
+				if tt.hidden != "" && strings.Contains(w.Body.String(), tt.hidden) {
+					t.Errorf("%s %s returned the other tenant's transaction: %s", tt.method, tt.path, w.Body.String())
+				}
+			}
+		})
+	}
+	w = doRequest(h, "GET", "/transaction/"+id, "", tenantA)
+	if w.Code != http.StatusOK {
+		t.Errorf("owner GET returned %d, want 200", w.Code)
+	}
+	w = doRequest(h, "POST", "/transaction", `{"txid":"`+id+`","blockchain":"eth"}`, map[string]string{tenantHeader: "b"})
+	if w.Code != http.StatusConflict {
+		t.Errorf("creating another tenant's hash returned %d, want 409", w.Code)
+	}
+	if code := errorResponseCode(t, w); code != CodeConflict {
+		t.Errorf("creating another tenant's hash returned code %s, want %s", code, CodeConflict)
+	}
+}
+
+func TestTenantKeys(t *testing.T) {
+	t.Setenv("TENANT_KEYS", "a=key-a,b=key-b")
+	t.Setenv("API_KEYS", "admin")
+	h := setupTestAPI(t)
+	id := testTxID("a")
+	w := doRequest(h, "POST", "/transaction", `{"txid":"`+id+`","blockchain":"eth"}`, map[string]string{"Authorization": "Bearer key-a"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", w.Code, w.Body.String())
+	}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"bound tenant", map[string]string{"Authorization": "Bearer key-a"}, http.StatusOK},
+		{"bound tenant with its header", map[string]string{"Authorization": "Bearer key-a", tenantHeader: "a"}, http.StatusOK},
+		{"other tenant", map[string]string{"Authorization": "Bearer key-b"}, http.StatusNotFound},
+		{"other tenant claiming the tenant", map[string]string{"Authorization": "Bearer key-b", tenantHeader: "a"}, http.StatusForbidden},
+		{"admin key with the tenant", map[string]string{"Authorization": "Bearer admin", tenantHeader: "a"}, http.StatusOK},
+		{"admin key without a tenant", map[string]string{"Authorization": "Bearer admin"}, http.StatusNotFound},
+		{"unknown key", map[string]string{"Authorization": "Bearer key-c", tenantHeader: "a"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(h, "GET", "/transaction/"+id, "", tt.headers)
+			if w.Code != tt.status {
+				t.Errorf("got %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTenantEvents(t *testing.T) {
+	h := setupTestAPI(t)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	// replay only the events published by this test
+	var last uint64
+	if evs := etx.Updates.Since(0); len(evs) > 0 {
+		last = evs[len(evs)-1].ID
+	}
+	etx.Updates.Publish(etx.Transaction{ID: testTxID("a"), Blockchain: "eth", TenantID: "a"})
+	etx.Updates.Publish(etx.Transaction{ID: testTxID("b"), Blockchain: "eth", TenantID: "b"})
+	etx.Updates.Publish(etx.Transaction{ID: testTxID("c"), Blockchain: "eth"})
+	tests := []struct {
+		tenant string
+		want   string
+	}{
+		{"a", testTxID("a")},
+		{"b", testTxID("b")},
+		{"", testTxID("c")},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		r, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/transactions/events", nil)
+		r.Header.Set("Last-Event-ID", strconv.FormatUint(last, 10))
+		if tt.tenant != "" {
+			r.Header.Set(tenantHeader, tt.tenant)
+		}
+		var body []byte
+		if res, err := http.DefaultClient.Do(r); err == nil {
+			body, _ = ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		cancel()
+		if n := strings.Count(string(body), "data: "); n != 1 || !strings.Contains(string(body), tt.want) {
+			t.Errorf("tenant %q received %d events, want only %s: %s", tt.tenant, n, tt.want, body)
+		}
+	}
+}
+
+func TestRequeueTransaction(t *testing.T) {
+	tests := []struct {
+		name   string
+		tx     etx.Transaction
+		force  bool
+		status int
+	}{
+		{"monitored", etx.Transaction{Monitoring: true}, false, http.StatusConflict},
+		{"succeeded", etx.Transaction{Success: true, BlockNumber: 16}, false, http.StatusConflict},
+		{"succeeded with force", etx.Transaction{Success: true, BlockNumber: 16}, true, http.StatusConflict},
+		{"failed", etx.Transaction{Error: "exceeded checks threshold"}, false, http.StatusOK},
+		{"reverted", etx.Transaction{Error: "failure: nope", BlockNumber: 16}, false, http.StatusConflict},
+		{"reverted with force", etx.Transaction{Error: "failure: nope", BlockNumber: 16}, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTestAPI(t)
+			tx := tt.tx
+			tx.ID = testTxID("a")
+			tx.Blockchain = "eth"
+			if err := etx.DB.Create(&tx).Error; err != nil {
+				t.Fatal(err)
+			}
+			path := "/transaction/" + tx.ID + "/requeue"
+			if tt.force {
+				path += "?force=true"
+			}
+			w := doRequest(h, "POST", path, "", nil)
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			st := &etx.Transaction{}
+			etx.DB.Find(st, "id = ?", tx.ID)
+			if monitoring := tt.status == http.StatusOK || tt.tx.Monitoring; st.Monitoring != monitoring {
+				t.Errorf("got monitoring=%v, want %v", st.Monitoring, monitoring)
+			}
+		})
+	}
+}
+
+func TestDeleteTransaction(t *testing.T) {
+	h := setupTestAPI(t)
+	id := testTxID("a")
+	w := doRequest(h, "POST", "/transaction", `{"txid":"`+id+`","blockchain":"eth"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", w.Code, w.Body.String())
+	}
+	tests := []struct {
+		method string
+		status int
+	}{
+		{"DELETE", http.StatusNoContent},
+		{"GET", http.StatusNotFound},
+		{"DELETE", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := doRequest(h, tt.method, "/transaction/"+id, "", nil); w.Code != tt.status {
+			t.Errorf("%s returned %d, want %d: %s", tt.method, w.Code, tt.status, w.Body.String())
+		}
+	}
+}
+
+// seedTransactions stores transactions directly in the database, defaulting
+// their blockchain to eth
+func seedTransactions(t *testing.T, txs ...etx.Transaction) {
+	t.Helper()
+	for i := range txs {
+		if txs[i].Blockchain == "" {
+			txs[i].Blockchain = "eth"
+		}
+		if err := etx.DB.Create(&txs[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listTransactions returns the IDs of the transactions listed by
+// POST /transactions with the provided query
+func listTransactions(t *testing.T, h http.Handler, query string) []string {
+	t.Helper()
+	w := doRequest(h, "POST", "/transactions?"+query, "{}", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("listing with %q returned %d: %s", query, w.Code, w.Body.String())
+	}
+	tp := TransactionsPage{}
+	if err := json.Unmarshal(w.Body.Bytes(), &tp); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, tx := range tp.Data {
+		ids = append(ids, tx.ID)
+	}
+	return ids
+}
+
+func TestStatusFilter(t *testing.T) {
+	h := setupTestAPI(t)
+	seedTransactions(t,
+		etx.Transaction{ID: testTxID("1"), Monitoring: true, Pending: true},
+		etx.Transaction{ID: testTxID("2"), Monitoring: true},
+		etx.Transaction{ID: testTxID("3"), Success: true},
+		etx.Transaction{ID: testTxID("4"), Error: "failure"},
+	)
+	tests := []struct {
+		status string
+		want   []string
+	}{
+		{"", []string{testTxID("1"), testTxID("2"), testTxID("3"), testTxID("4")}},
+		{"pending", []string{testTxID("1")}},
+		{"monitoring", []string{testTxID("1"), testTxID("2")}},
+		{"success", []string{testTxID("3")}},
+		{"failed", []string{testTxID("4")}},
+	}
+	for _, tt := range tests {
+		t.Run("status="+tt.status, func(t *testing.T) {
+			got := listTransactions(t, h, "status="+tt.status)
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+	w := doRequest(h, "POST", "/transactions?status=done", "{}", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid status returned %d, want 400", w.Code)
+	}
+}
+
+func TestDBDialector(t *testing.T) {
+	tests := []struct {
+		driver string
+		name   string
+		fail   bool
+	}{
+		{"", "postgres", false},
+		{"postgres", "postgres", false},
+		{"mysql", "mysql", false},
+		{"sqlite", "sqlite", false},
+		{"oracle", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("DB_DRIVER", tt.driver)
+		d, err := dbDialector("localhost")
+		if (err != nil) != tt.fail {
+			t.Errorf("DB_DRIVER=%q returned error %v, want failure %v", tt.driver, err, tt.fail)
+			continue
+		}
+		if err == nil && d.Name() != tt.name {
+			t.Errorf("DB_DRIVER=%q returned the %s dialector, want %s", tt.driver, d.Name(), tt.name)
+		}
+	}
+}
+
+func TestOpenDBTablePrefix(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_NAME", filepath.Join(t.TempDir(), "txwatch.db"))
+	tests := []struct {
+		prefix string
+	}{
+		{""},
+		{"a_"},
+		{"b_"},
+	}
+	for _, tt := range tests {
+		t.Run("prefix="+tt.prefix, func(t *testing.T) {
+			t.Setenv("DB_TABLE_PREFIX", tt.prefix)
+			db, err := openDB("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sqlDB, err := db.DB(); err == nil {
+				defer sqlDB.Close()
+			}
+			// every prefix migrates into the same database
+			if err := etx.Migrate(db); err != nil {
+				t.Fatal(err)
+			}
+			if err := etx.Migrate(db); err != nil {
+				t.Fatalf("migrating again: %v", err)
+			}
+			for _, table := range []string{"transactions", "watched_addresses", "transaction_events"} {
+				if !db.Migrator().HasTable(tt.prefix + table) {
+					t.Errorf("%s table was not created with the DB_TABLE_PREFIX", tt.prefix+table)
+				}
+			}
+			if idx := "idx_" + tt.prefix + "transactions_monitored"; !db.Migrator().HasIndex(&etx.Transaction{}, idx) {
+				t.Errorf("index %s was not created", idx)
+			}
+			if idx := "idx_" + tt.prefix + "watched_addresses_address"; !db.Migrator().HasIndex(&etx.WatchedAddress{}, idx) {
+				t.Errorf("index %s was not created", idx)
+			}
+			if err := db.Create(&etx.Transaction{ID: testTxID("a"), Blockchain: "eth"}).Error; err != nil {
+				t.Fatal(err)
+			}
+			st := &etx.Transaction{}
+			if res := db.Find(st, "id = ?", testTxID("a")); res.RowsAffected != 1 {
+				t.Errorf("got %d transactions, want 1", res.RowsAffected)
+			}
+			wa := etx.WatchedAddress{Address: "0x0000000000000000000000000000000000000001", Blockchain: "eth"}
+			if err := db.Create(&wa).Error; err != nil {
+				t.Fatal(err)
+			}
+			wa.ID = 0
+			if err := db.Create(&wa).Error; err == nil {
+				t.Errorf("watched the same address twice, want a unique index violation")
+			}
+		})
+	}
+}
+
+func TestMergeMetadata(t *testing.T) {
+	h := setupTestAPI(t)
+	id := testTxID("a")
+	w := doRequest(h, "POST", "/transaction", `{"txid":"`+id+`","blockchain":"eth","metadata":{"source":"api"}}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", w.Code, w.Body.String())
+	}
+	tests := []struct {
+		body string
+		want etx.MetadataMap
+	}{
+		{`{"order":"1"}`, etx.MetadataMap{"source": "api", "order": "1"}},
+		{`{"invoice":"2"}`, etx.MetadataMap{"source": "api", "order": "1", "invoice": "2"}},
+		{`{"order":"3"}`, etx.MetadataMap{"source": "api", "order": "3", "invoice": "2"}},
+	}
+	for _, tt := range tests {
+		w := doRequest(h, "PATCH", "/transaction/"+id+"/metadata", tt.body, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("PATCH %s returned %d: %s", tt.body, w.Code, w.Body.String())
+		}
+		got := etx.MetadataMap{}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("PATCH %s returned %v, want %v", tt.body, got, tt.want)
+		}
+	}
+	st := &etx.Transaction{}
+	etx.DB.Find(st, "id = ?", id)
+	if !reflect.DeepEqual(st.Metadata, tests[len(tests)-1].want) {
+		t.Errorf("stored metadata %v, want %v", st.Metadata, tests[len(tests)-1].want)
+	}
+	if w := doRequest(h, "PATCH", "/transaction/"+testTxID("b")+"/metadata", `{"k":"v"}`, nil); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH of an unknown transaction returned %d, want 404", w.Code)
+	}
+}
+
+func TestSetReviewedNotFound(t *testing.T) {
+	h := setupTestAPI(t)
+	w := doRequest(h, "POST", "/transaction/"+testTxID("a")+"/reviewed", `{"reviewed":true}`, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("reviewing an unknown transaction returned %d, want 404: %s", w.Code, w.Body.String())
+	}
+	if code := errorResponseCode(t, w); code != CodeNotFound {
+		t.Errorf("got code %s, want %s", code, CodeNotFound)
+	}
+}
+
+func TestSetReviewed(t *testing.T) {
+	h := setupTestAPI(t)
+	id := testTxID("a")
+	seedTransactions(t, etx.Transaction{ID: id, Success: true})
+	tests := []struct {
+		body     string
+		status   int
+		reviewed bool
+	}{
+		{`{"reviewed":true}`, http.StatusOK, true},
+		{``, http.StatusBadRequest, true},
+		{`{}`, http.StatusBadRequest, true},
+		{`{"reviewed":null}`, http.StatusBadRequest, true},
+		{`{"reviewed":"yes"}`, http.StatusBadRequest, true},
+		{`{"reviewed":false}`, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		w := doRequest(h, "POST", "/transaction/"+id+"/reviewed", tt.body, nil)
+		if w.Code != tt.status {
+			t.Errorf("body %q returned %d, want %d: %s", tt.body, w.Code, tt.status, w.Body.String())
+		}
+		st := &etx.Transaction{}
+		etx.DB.Find(st, "id = ?", id)
+		if st.Reviewed != tt.reviewed {
+			t.Errorf("after body %q got reviewed=%v, want %v", tt.body, st.Reviewed, tt.reviewed)
+		}
+	}
+}
+
+func TestListenPort(t *testing.T) {
+	tests := []struct {
+		port string
+		want string
+		fail bool
+	}{
+		{"", "8080", false},
+		{"9000", "9000", false},
+		{"1", "1", false},
+		{"65535", "65535", false},
+		{"0", "", true},
+		{"65536", "", true},
+		{"http", "", true},
+		{":8080", "", true},
+	}
+	for _, tt := range tests {
+		t.Setenv("PORT", tt.port)
+		got, err := listenPort()
+		if got != tt.want || (err != nil) != tt.fail {
+			t.Errorf("PORT=%q returned %q, %v, want %q and failure %v", tt.port, got, err, tt.want, tt.fail)
+		}
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "256")
+	h := setupTestAPI(t)
+	oversized := `{"txid":"` + testTxID("a") + `","blockchain":"eth","metadata":{"note":"` + strings.Repeat("x", 256) + `"}}`
+	tests := []struct {
+		method, path string
+	}{
+		{"POST", "/transaction"},
+		{"POST", "/transactions"},
+		{"POST", "/transactions/bulk"},
+		{"PATCH", "/transaction/" + testTxID("a") + "/metadata"},
+	}
+	for _, tt := range tests {
+		w := doRequest(h, tt.method, tt.path, oversized, nil)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s returned %d, want 413: %s", tt.method, tt.path, w.Code, w.Body.String())
+			continue
+		}
+		if code := errorResponseCode(t, w); code != CodePayloadTooLarge {
+			t.Errorf("%s %s returned code %s, want %s", tt.method, tt.path, code, CodePayloadTooLarge)
+		}
+	}
+	w := doRequest(h, "POST", "/transaction", `{"txid":"`+testTxID("a")+`","blockchain":"eth"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Errorf("body under the limit returned %d, want 201: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestNewTransactionValidation(t *testing.T) {
+	h := setupTestAPI(t)
+	id := testTxID("a")
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   ErrorCode
+	}{
+		{"valid", `{"txid":"` + id + `","blockchain":"eth"}`, http.StatusCreated, ""},
+		{"unknown field", `{"txid":"` + testTxID("b") + `","blockchain":"eth","callback":"x"}`, http.StatusBadRequest, CodeBadRequest},
+		{"miscased field", `{"txId":"` + testTxID("b") + `","blockchain":"eth"}`, http.StatusBadRequest, CodeBadRequest},
+		{"empty body", ``, http.StatusBadRequest, CodeBadRequest},
+		{"malformed", `{"txid":`, http.StatusBadRequest, CodeBadRequest},
+		{"missing txid", `{"blockchain":"eth"}`, http.StatusBadRequest, CodeInvalidTxID},
+		{"invalid txid", `{"txid":"0x1234","blockchain":"eth"}`, http.StatusBadRequest, CodeInvalidTxID},
+		{"missing blockchain", `{"txid":"` + testTxID("b") + `"}`, http.StatusBadRequest, CodeInvalidBlockchain},
+		{"unknown blockchain", `{"txid":"` + testTxID("b") + `","blockchain":"sol"}`, http.StatusBadRequest, CodeInvalidBlockchain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(h, "POST", "/transaction", tt.body, nil)
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.code != "" {
+				if code := errorResponseCode(t, w); code != tt.code {
+					t.Errorf("got code %s, want %s", code, tt.code)
+				}
+			}
+		})
+	}
+	w := doRequest(h, "POST", "/transactions/bulk", `[{"txid":"`+testTxID("c")+`","Blockchain":"eth"}]`, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bulk request with a miscased field returned %d, want 400: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestJSONContentType(t *testing.T) {
+	h := setupTestAPI(t)
+	id := testTxID("a")
+	tests := []struct {
+		method, path, body string
+	}{
+		{"POST", "/transaction", `{"txid":"` + id + `","blockchain":"eth"}`},
+		{"GET", "/transaction/" + id, ""},
+		{"GET", "/transaction/" + id + "/history", ""},
+		{"PATCH", "/transaction/" + id + "/metadata", `{"k":"v"}`},
+		{"POST", "/transactions", `{}`},
+		{"POST", "/transactions?envelope=false", `{}`},
+		{"GET", "/transactions/count", ""},
+		{"GET", "/transactions/pending-review", ""},
+		{"GET", "/transactions/blockchains", ""},
+		{"GET", "/blockchains", ""},
+		{"GET", "/status/info", ""},
+		{"GET", "/transaction/" + testTxID("b"), ""},
+		{"POST", "/transaction", `{`},
+	}
+	for _, tt := range tests {
+		w := doRequest(h, tt.method, tt.path, tt.body, nil)
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s returned %d with Content-Type %q, want application/json", tt.method, tt.path, w.Code, ct)
+		}
+	}
+}
+
+func TestPageParams(t *testing.T) {
+	tests := []struct {
+		name           string
+		max, def       string
+		query          string
+		page, pageSize int
+	}{
+		{"defaults", "", "", "", 1, 10},
+		{"requested", "", "", "page=3&pageSize=25", 3, 25},
+		{"capped", "", "", "pageSize=1000", 1, 100},
+		{"configured cap", "20", "", "pageSize=50", 1, 20},
+		{"configured default", "", "5", "", 1, 5},
+		{"default above cap", "20", "50", "", 1, 20},
+		{"invalid size", "", "", "pageSize=-1", 1, 10},
+		{"invalid env", "none", "-5", "pageSize=500", 1, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAX_PAGE_SIZE", tt.max)
+			t.Setenv("DEFAULT_PAGE_SIZE", tt.def)
+			r := httptest.NewRequest("POST", "/transactions?"+tt.query, nil)
+			page, pageSize := pageParams(r)
+			if page != tt.page || pageSize != tt.pageSize {
+				t.Errorf("pageParams(%q) = %d, %d, want %d, %d", tt.query, page, pageSize, tt.page, tt.pageSize)
+			}
+		})
+	}
+}
+
+func TestPageSizeCap(t *testing.T) {
+	t.Setenv("MAX_PAGE_SIZE", "3")
+	h := setupTestAPI(t)
+	for _, c := range "12345" {
+		seedTransactions(t, etx.Transaction{ID: testTxID(string(c))})
+	}
+	if got := listTransactions(t, h, "pageSize=50"); len(got) != 3 {
+		t.Errorf("listed %d transactions, want the cap of 3", len(got))
+	}
+}
+
+func TestBulkTransactions(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		status []int
+		codes  []ErrorCode
+	}{
+		{
+			"lenient", false,
+			[]int{http.StatusCreated, http.StatusCreated, http.StatusOK, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest},
+			[]ErrorCode{"", "", "", CodeInvalidBlockchain, CodeInvalidTxID, CodeBadRequest},
+		},
+		{
+			"strict", true,
+			[]int{http.StatusCreated, http.StatusCreated, http.StatusConflict, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest},
+			[]ErrorCode{"", "", CodeExists, CodeInvalidBlockchain, CodeInvalidTxID, CodeBadRequest},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTestAPI(t)
+			seedTransactions(t, etx.Transaction{ID: testTxID("3"), Blockchain: "poly"})
+			body := `[
+				{"txid":"` + testTxID("1") + `","blockchain":"poly"},
+				{"txid":"` + testTxID("2") + `","blockchain":"eth"},
+				{"txid":"` + testTxID("3") + `","blockchain":"poly"},
+				{"txid":"` + testTxID("4") + `","blockchain":"bsc"},
+				{"txid":"0x1234","blockchain":"eth"},
+				null
+			]`
+			w := doRequest(h, "POST", "/transactions/bulk?strict="+strconv.FormatBool(tt.strict), body, nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("bulk request returned %d: %s", w.Code, w.Body.String())
+			}
+			var results []BulkResult
+			if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tt.status) {
+				t.Fatalf("got %d results, want %d", len(results), len(tt.status))
+			}
+			for i, res := range results {
+				if res.Status != tt.status[i] || res.Code != tt.codes[i] {
+					t.Errorf("result %d = %d %q, want %d %q", i, res.Status, res.Code, tt.status[i], tt.codes[i])
+				}
+			}
+			for id, want := range map[string]string{testTxID("1"): "poly", testTxID("2"): "eth"} {
+				st := etx.Transaction{}
+				if err := etx.DB.First(&st, "id = ?", id).Error; err != nil {
+					t.Fatalf("finding %s: %v", id, err)
+				}
+				if st.Blockchain != want || !st.Monitoring {
+					t.Errorf("stored %s on %q with monitoring %v, want %q and monitoring", id, st.Blockchain, st.Monitoring, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBulkTransactionsLimits(t *testing.T) {
+	t.Setenv("MAX_BULK_TRANSACTIONS", "2")
+	h := setupTestAPI(t)
+	tx := func(c string) string {
+		return `{"txid":"` + testTxID(c) + `","blockchain":"eth"}`
+	}
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"empty", `[]`, http.StatusBadRequest},
+		{"at limit", "[" + tx("1") + "," + tx("2") + "]", http.StatusOK},
+		{"over limit", "[" + tx("3") + "," + tx("4") + "," + tx("5") + "]", http.StatusBadRequest},
+		{"not an array", tx("6"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := doRequest(h, "POST", "/transactions/bulk", tt.body, nil); w.Code != tt.status {
+				t.Errorf("got %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetTransactionsDBError(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		fail  func(db *gorm.DB) bool
+	}{
+		{"find", "", func(db *gorm.DB) bool { return true }},
+		{"find without envelope", "envelope=false", func(db *gorm.DB) bool { return true }},
+		{"count", "", func(db *gorm.DB) bool {
+			_, count := db.Statement.Dest.(*int64)
+			return count
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := setupTestAPI(t)
+			seedTransactions(t, etx.Transaction{ID: testTxID("1")})
+			etx.ReadDB.Callback().Query().Before("gorm:query").Register("test:fail", func(db *gorm.DB) {
+				if tt.fail(db) {
+					db.AddError(errors.New("database is locked"))
+				}
+			})
+			w := doRequest(h, "POST", "/transactions?"+tt.query, "{}", nil)
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("got %d, want 500: %s", w.Code, w.Body.String())
+			}
+			if code := errorResponseCode(t, w); code != CodeInternal {
+				t.Errorf("got code %s, want %s", code, CodeInternal)
+			}
+		})
+	}
+}
+
+func TestReplaceTransaction(t *testing.T) {
+	h := setupTestAPI(t)
+	oldID, newID := testTxID("a"), testTxID("b")
+	tenantA := map[string]string{tenantHeader: "a"}
+	w := doRequest(h, "POST", "/transaction", `{"txid":"`+oldID+`","blockchain":"poly","metadata":{"order":"42"},"callbackUrl":"https://example.com/hook"}`, tenantA)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", w.Code, w.Body.String())
+	}
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		headers map[string]string
+		status  int
+		code    ErrorCode
+	}{
+		{"invalid txid", "/transaction/" + oldID + "/replace", `{"txid":"0x1234"}`, tenantA, http.StatusBadRequest, CodeInvalidTxID},
+		{"other tenant", "/transaction/" + oldID + "/replace", `{"txid":"` + newID + `"}`, map[string]string{tenantHeader: "b"}, http.StatusNotFound, CodeNotFound},
+		{"unknown", "/transaction/" + testTxID("c") + "/replace", `{"txid":"` + testTxID("d") + `"}`, tenantA, http.StatusNotFound, CodeNotFound},
+		{"replaced", "/transaction/" + oldID + "/replace", `{"txid":"` + newID + `"}`, tenantA, http.StatusCreated, ""},
+		{"replacement watched", "/transaction/" + oldID + "/replace", `{"txid":"` + newID + `"}`, tenantA, http.StatusConflict, CodeExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(h, "POST", tt.path, tt.body, tt.headers)
+			if w.Code != tt.status {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.code != "" {
+				if code := errorResponseCode(t, w); code != tt.code {
+					t.Errorf("got code %s, want %s", code, tt.code)
+				}
+			}
+		})
+	}
+	nt := etx.Transaction{}
+	w = doRequest(h, "GET", "/transaction/"+newID, "", tenantA)
+	if err := json.Unmarshal(w.Body.Bytes(), &nt); err != nil {
+		t.Fatalf("decoding replacement %q: %v", w.Body.String(), err)
+	}
+	if !nt.Monitoring || nt.Blockchain != "poly" || nt.TenantID != "a" || nt.CallbackURL != "https://example.com/hook" || nt.Metadata["order"] != "42" {
+		t.Errorf("got replacement %+v, want the metadata, callback, tenant and blockchain of %s", nt, oldID)
+	}
+	ot := etx.Transaction{}
+	w = doRequest(h, "GET", "/transaction/"+oldID, "", tenantA)
+	if err := json.Unmarshal(w.Body.Bytes(), &ot); err != nil {
+		t.Fatalf("decoding replaced transaction %q: %v", w.Body.String(), err)
+	}
+	if ot.ReplacedBy != newID || ot.Monitoring {
+		t.Errorf("got replaced transaction replacedBy=%q monitoring=%v, want replacedBy=%s and not monitored", ot.ReplacedBy, ot.Monitoring, newID)
+	}
+}
+
+func TestWriteTransaction(t *testing.T) {
+	// times after year 9999 cannot be encoded as JSON
+	unencodable := time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		tx     *etx.Transaction
+		status int
+		want   int
+		code   ErrorCode
+	}{
+		{"ok", &etx.Transaction{ID: testTxID("a")}, http.StatusOK, http.StatusOK, ""},
+		{"created", &etx.Transaction{ID: testTxID("a")}, http.StatusCreated, http.StatusCreated, ""},
+		{"unencodable", &etx.Transaction{ID: testTxID("a"), DeadlineAt: &unencodable}, http.StatusOK, http.StatusInternalServerError, CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeTransaction(w, tt.tx, tt.status)
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got Content-Type %q, want application/json", ct)
+			}
+			if tt.code != "" {
+				if code := errorResponseCode(t, w); code != tt.code {
+					t.Errorf("got code %s, want %s", code, tt.code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// isStatusPath reports whether a request is for one of the /status endpoints
+func isStatusPath(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, routePrefix()+"/status/")
+}
+
+// apiKeys parses the comma separated API_KEYS env var
+func apiKeys() []string {
+	var keys []string
+	for _, k := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// tenantKeys parses the comma separated TENANT_KEYS env var of <tenant>=<key>
+// pairs into a map of each key to its tenant
+func tenantKeys() map[string]string {
+	keys := make(map[string]string)
+	for _, e := range strings.Split(os.Getenv("TENANT_KEYS"), ",") {
+		ss := strings.SplitN(strings.TrimSpace(e), "=", 2)
+		if len(ss) != 2 || strings.TrimSpace(ss[0]) == "" || strings.TrimSpace(ss[1]) == "" {
+			continue
+		}
+		keys[strings.TrimSpace(ss[1])] = strings.TrimSpace(ss[0])
+	}
+	return keys
+}
+
+// validKey reports whether key matches one of keys
+func validKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// keyTenant returns the tenant of key in tenants, comparing every key in
+// constant time
+func keyTenant(tenants map[string]string, key string) (string, bool) {
+	tenant, found := "", false
+	for k, t := range tenants {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			tenant, found = t, true
+		}
+	}
+	return tenant, found
+}
+
+// authKey returns the API key of a request's "Authorization: Bearer <key>"
+// header, or an empty string if the header does not use the Bearer scheme
+func authKey(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(h, "Bearer ")
+}
+
+// tenantContextKey is the request context key of the tenant bound to an API key
+type tenantContextKey struct{}
+
+// AuthMiddleware requires an "Authorization: Bearer <key>" header matching one
+// of the keys in API_KEYS or TENANT_KEYS. Requests with a key from TENANT_KEYS
+// are bound to its tenant, and are forbidden from sending the X-Tenant-ID header
+// of another tenant. Authentication is disabled when neither is set, and /status
+// endpoints are always open for load balancers
+func AuthMiddleware(next http.Handler) http.Handler {
+	keys := apiKeys()
+	tenants := tenantKeys()
+	if len(keys) == 0 && len(tenants) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStatusPath(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		l := log.WithFields(log.Fields{
+			"action": "AuthMiddleware",
+			"path":   r.URL.Path,
+		})
+		key := authKey(r)
+		if key != "" && validKey(keys, key) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenant, ok := keyTenant(tenants, key)
+		if key == "" || !ok {
+			l.Println("unauthorized")
+			jsonError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if h := strings.TrimSpace(r.Header.Get(tenantHeader)); h != "" && h != tenant {
+			l.Printf("forbidden tenant %q", h)
+			jsonError(w, "API key is not valid for the "+tenantHeader+" tenant", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, tenant)))
+	})
+}
+
+// clientLimiter is the token bucket of a single client
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter holds a token bucket per client, keyed by API key or remote IP
+type rateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+	rps     rate.Limit
+	burst   int
+}
+
+// get returns the limiter for a client, creating it if needed
+func (rl *rateLimiter) get(key string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	c, ok := rl.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(rl.rps, rl.burst)}
+		rl.clients[key] = c
+	}
+	c.lastSeen = time.Now()
+	return c.limiter
+}
+
+// cleanup removes limiters for clients which have not been seen recently
+func (rl *rateLimiter) cleanup(idle time.Duration) {
+	for {
+		time.Sleep(idle)
+		rl.mu.Lock()
+		for k, c := range rl.clients {
+			if time.Since(c.lastSeen) > idle {
+				delete(rl.clients, k)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// clientKey identifies a client by its API key if it is one of keys, or by its
+// remote IP otherwise. Unknown keys are not trusted, as the limiter runs before
+// authentication and a client could otherwise send a new key with each request
+func clientKey(r *http.Request, keys []string) string {
+	if key := authKey(r); key != "" && validKey(keys, key) {
+		return "key:" + key
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// RateLimitMiddleware returns a middleware limiting each client to RATE_LIMIT_RPS
+// requests per second with bursts of up to RATE_LIMIT_BURST requests (default
+// RATE_LIMIT_RPS). Clients exceeding the limit receive a 429 with a Retry-After
+// header. Rate limiting is disabled when RATE_LIMIT_RPS is not set
+func RateLimitMiddleware() mux.MiddlewareFunc {
+	rps, rerr := strconv.ParseFloat(os.Getenv("RATE_LIMIT_RPS"), 64)
+	if rerr != nil || rps <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	burst, berr := strconv.Atoi(os.Getenv("RATE_LIMIT_BURST"))
+	if berr != nil || burst <= 0 {
+		burst = int(math.Ceil(rps))
+	}
+	rl := &rateLimiter{
+		clients: make(map[string]*clientLimiter),
+		rps:     rate.Limit(rps),
+		burst:   burst,
+	}
+	keys := apiKeys()
+	for k := range tenantKeys() {
+		keys = append(keys, k)
+	}
+	go rl.cleanup(time.Minute * 5)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := rl.get(clientKey(r, keys)).Reserve()
+			if d := res.Delay(); d > 0 {
+				res.Cancel()
+				log.WithFields(log.Fields{
+					"action": "RateLimitMiddleware",
+					"path":   r.URL.Path,
+				}).Println("rate limited")
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+				jsonError(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tenantHeader is the header identifying the tenant of a request
+const tenantHeader = "X-Tenant-ID"
+
+// tenantID returns the tenant bound to the API key of a request, or the tenant
+// in its X-Tenant-ID header. It returns an empty string if the request has none
+func tenantID(r *http.Request) string {
+	if tenant, ok := r.Context().Value(tenantContextKey{}).(string); ok {
+		return tenant
+	}
+	return strings.TrimSpace(r.Header.Get(tenantHeader))
+}
+
+// TenantMiddleware rejects requests without an X-Tenant-ID header when
+// TENANT_REQUIRED is true. The /status endpoints do not require a tenant
+func TenantMiddleware(next http.Handler) http.Handler {
+	if os.Getenv("TENANT_REQUIRED") != "true" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isStatusPath(r) && tenantID(r) == "" {
+			writeErrorCode(w, tenantHeader+" header required", http.StatusBadRequest, CodeTenantRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxBodyBytes returns the maximum size of a request body, configured in
+// bytes with MAX_BODY_BYTES and defaulting to 1MB
+func maxBodyBytes() int64 {
+	mb, merr := strconv.ParseInt(os.Getenv("MAX_BODY_BYTES"), 10, 64)
+	if merr != nil || mb <= 0 {
+		return 1 << 20
+	}
+	return mb
+}
+
+// BodyLimitMiddleware returns a middleware limiting request bodies to
+// maxBodyBytes. Reading past the limit fails, see bodyErrorStatus
+func BodyLimitMiddleware() mux.MiddlewareFunc {
+	limit := maxBodyBytes()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyErrorStatus returns the status code for an error reading a request body,
+// which is 413 if the body exceeded the limit set by BodyLimitMiddleware
+func bodyErrorStatus(err error) int {
+	// http.MaxBytesError is not available before go 1.19
+	if err.Error() == "http: request body too large" {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// gzipMinBytes returns the size from which responses are compressed, configured
+// with GZIP_MIN_BYTES and defaulting to 1KB
+func gzipMinBytes() int {
+	mb, merr := strconv.Atoi(os.Getenv("GZIP_MIN_BYTES"))
+	if merr != nil || mb <= 0 {
+		return 1024
+	}
+	return mb
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of a request allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		ss := strings.Split(e, ";")
+		if strings.TrimSpace(ss[0]) != "gzip" {
+			continue
+		}
+		for _, p := range ss[1:] {
+			if q := strings.TrimSpace(p); strings.HasPrefix(q, "q=") && strings.Trim(q[2:], "0.") == "" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers a response until it reaches minSize bytes, then
+// compresses it. Smaller responses, responses which are already encoded, event
+// streams and other streamed responses are written uncompressed
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.started {
+		return
+	}
+	g.status = code
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if g.started {
+		if g.gz != nil {
+			return g.gz.Write(b)
+		}
+		return g.ResponseWriter.Write(b)
+	}
+	g.buf = append(g.buf, b...)
+	if len(g.buf) >= g.minSize {
+		if err := g.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start writes the header, deciding whether the response is compressed,
+// followed by the buffered body. Event streams are never compressed, since
+// compressed events would be held back until the gzip writer is flushed
+func (g *gzipResponseWriter) start(compress bool) error {
+	g.started = true
+	h := g.Header()
+	eventStream := strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+	if compress && !eventStream && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	if g.status != 0 {
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if g.gz != nil {
+		_, err = g.gz.Write(buf)
+	} else {
+		_, err = g.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends streamed responses, such as HandleTransactionEvents, uncompressed
+// if they have not been compressed yet
+func (g *gzipResponseWriter) Flush() {
+	if !g.started {
+		g.start(false)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close writes a response which is smaller than minSize, or finishes compressing it
+func (g *gzipResponseWriter) close() {
+	if !g.started {
+		g.start(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+// GzipMiddleware returns a middleware compressing the responses of clients which
+// accept gzip when they are larger than gzipMinBytes. WebSocket upgrades and
+// event streams are not compressed
+func GzipMiddleware() mux.MiddlewareFunc {
+	minSize := gzipMinBytes()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r) || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// CORSHandler wraps a handler to set CORS headers for requests from origins in
+// the comma separated CORS_ALLOWED_ORIGINS env var, where "*" allows any origin,
+// and to answer preflight OPTIONS requests. CORS is disabled when
+// CORS_ALLOWED_ORIGINS is not set
+func CORSHandler(next http.Handler) http.Handler {
+	origins := make(map[string]bool)
+	for _, o := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins[o] = true
+		}
+	}
+	if len(origins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !(origins["*"] || origins[origin]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Last-Event-ID, "+tenantHeader)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush supports streaming handlers such as HandleTransactionEvents
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack supports the WebSocket upgrade in HandleTransactionStream
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// LoggingHandler wraps a handler to emit a structured access log entry for every
+// request. The request ID is taken from the X-Request-ID header, or generated,
+// and returned in the X-Request-ID response header
+func LoggingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rid := r.Header.Get("X-Request-ID")
+		if rid == "" {
+			rid = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", rid)
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sr, r)
+		log.WithFields(log.Fields{
+			"action":     "access",
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"status":     sr.status,
+			"durationMs": time.Since(start).Milliseconds(),
+			"requestId":  rid,
+			"remoteAddr": r.RemoteAddr,
+		}).Info("request")
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Setenv("API_KEYS", "key1, key2")
+	h := setupTestAPI(t)
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		status        int
+	}{
+		{"valid key", "/transactions/count", "Bearer key1", http.StatusOK},
+		{"second key", "/transactions/count", "Bearer key2", http.StatusOK},
+		{"missing header", "/transactions/count", "", http.StatusUnauthorized},
+		{"wrong key", "/transactions/count", "Bearer key3", http.StatusUnauthorized},
+		{"empty key", "/transactions/count", "Bearer ", http.StatusUnauthorized},
+		{"key without scheme", "/transactions/count", "key1", http.StatusUnauthorized},
+		{"other scheme", "/transactions/count", "Basic key1", http.StatusUnauthorized},
+		{"lowercase scheme", "/transactions/count", "bearer key1", http.StatusUnauthorized},
+		{"status endpoint", "/status/livez", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.authorization != "" {
+				headers["Authorization"] = tt.authorization
+			}
+			w := doRequest(h, "GET", tt.path, "", headers)
+			if w.Code != tt.status {
+				t.Errorf("got %d, want %d", w.Code, tt.status)
+			}
+			if w.Code == http.StatusUnauthorized {
+				if code := errorResponseCode(t, w); code != CodeUnauthorized {
+					t.Errorf("got code %s, want %s", code, CodeUnauthorized)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareDisabled(t *testing.T) {
+	t.Setenv("API_KEYS", "")
+	t.Setenv("TENANT_KEYS", "")
+	h := setupTestAPI(t)
+	if w := doRequest(h, "GET", "/transactions/count", "", nil); w.Code != http.StatusOK {
+		t.Errorf("got %d without API_KEYS, want 200", w.Code)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Setenv("RATE_LIMIT_RPS", "0.01")
+	t.Setenv("RATE_LIMIT_BURST", "2")
+	t.Setenv("API_KEYS", "key1")
+	h := setupTestAPI(t)
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"first request", "Bearer key1", http.StatusOK},
+		{"within burst", "Bearer key1", http.StatusOK},
+		{"burst exceeded", "Bearer key1", http.StatusTooManyRequests},
+		{"unknown key limited by ip", "Bearer random1", http.StatusUnauthorized},
+		{"second unknown key limited by ip", "Bearer random2", http.StatusUnauthorized},
+		{"ip burst exceeded with a new key", "Bearer random3", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		w := doRequest(h, "GET", "/transactions/count", "", map[string]string{"Authorization": tt.authorization})
+		if w.Code != tt.status {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: missing Retry-After header", tt.name)
+		}
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	keys := []string{"key1"}
+	tests := []struct {
+		name          string
+		authorization string
+		want          string
+	}{
+		{"valid key", "Bearer key1", "key:key1"},
+		{"unknown key", "Bearer key2", "ip:192.0.2.1"},
+		{"no key", "", "ip:192.0.2.1"},
+		{"key without scheme", "key1", "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r, _ := http.NewRequest("GET", "/transactions/count", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		if got := clientKey(r, keys); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	t.Setenv("GZIP_MIN_BYTES", "16")
+	large := strings.Repeat("a", 64)
+	tests := []struct {
+		name           string
+		contentType    string
+		body           string
+		acceptEncoding string
+		compressed     bool
+	}{
+		{"large response", "application/json", large, "gzip", true},
+		{"small response", "application/json", "a", "gzip", false},
+		{"gzip not accepted", "application/json", large, "", false},
+		{"gzip refused", "application/json", large, "gzip;q=0", false},
+		{"event stream", "text/event-stream", large, "gzip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := GzipMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(tt.body))
+			}))
+			w := doRequest(h, "GET", "/", "", map[string]string{"Accept-Encoding": tt.acceptEncoding})
+			compressed := w.Header().Get("Content-Encoding") == "gzip"
+			if compressed != tt.compressed {
+				t.Fatalf("got compressed=%v, want %v", compressed, tt.compressed)
+			}
+			var body []byte
+			if compressed {
+				zr, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ = ioutil.ReadAll(zr)
+			} else {
+				body = w.Body.Bytes()
+			}
+			if string(body) != tt.body {
+				t.Errorf("got body %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name    string
+		allowed string
+		method  string
+		origin  string
+		status  int
+		allow   string
+	}{
+		{"disabled", "", "GET", "https://app.example.com", http.StatusOK, ""},
+		{"allowed origin", "https://app.example.com, https://admin.example.com", "GET", "https://admin.example.com", http.StatusOK, "https://admin.example.com"},
+		{"other origin", "https://app.example.com", "GET", "https://evil.example.com", http.StatusOK, ""},
+		{"no origin", "https://app.example.com", "GET", "", http.StatusOK, ""},
+		{"any origin", "*", "GET", "https://evil.example.com", http.StatusOK, "https://evil.example.com"},
+		{"preflight", "https://app.example.com", "OPTIONS", "https://app.example.com", http.StatusNoContent, "https://app.example.com"},
+		{"preflight of other origin", "https://app.example.com", "OPTIONS", "https://evil.example.com", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CORS_ALLOWED_ORIGINS", tt.allowed)
+			headers := map[string]string{}
+			if tt.origin != "" {
+				headers["Origin"] = tt.origin
+			}
+			if tt.method == "OPTIONS" {
+				headers["Access-Control-Request-Method"] = "DELETE"
+			}
+			w := doRequest(CORSHandler(ok), tt.method, "/transaction/x", "", headers)
+			if w.Code != tt.status {
+				t.Errorf("got %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.allow)
+			}
+			if preflight := w.Code == http.StatusNoContent; preflight && !strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), "DELETE") {
+				t.Errorf("got Access-Control-Allow-Methods %q, want DELETE allowed", w.Header().Get("Access-Control-Allow-Methods"))
+			}
+			if preflight := w.Code == http.StatusNoContent; preflight && !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), tenantHeader) {
+				t.Errorf("got Access-Control-Allow-Headers %q, want %s allowed", w.Header().Get("Access-Control-Allow-Headers"), tenantHeader)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/robertlestak/txwatch/internal/etx"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// HandleTransactionStream is a WebSocket handler which sends a JSON message
+// every time a transaction is updated by the monitor. The optional
+// blockchain query parameter limits the stream to a single blockchain
+func HandleTransactionStream(w http.ResponseWriter, r *http.Request) {
+	blockchain := r.FormValue("blockchain")
+	tenant := tenantID(r)
+	l := log.WithFields(log.Fields{
+		"action":     "HandleTransactionStream",
+		"blockchain": blockchain,
+	})
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		l.Printf("error %v", err)
+		return
+	}
+	defer conn.Close()
+	l.Println("client connected")
+	sub := etx.Updates.Subscribe()
+	defer etx.Updates.Unsubscribe(sub)
+	// the client does not send messages, but reads are required to
+	// process control frames and detect when the client disconnects
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-closed:
+			l.Println("client disconnected")
+			return
+		case ev := <-sub:
+			if (blockchain != "" && ev.Transaction.Blockchain != blockchain) || ev.Transaction.TenantID != tenant {
+				continue
+			}
+			if err := conn.WriteJSON(ev.Transaction); err != nil {
+				l.Printf("error %v", err)
+				return
+			}
+		}
+	}
+}
+
+// HandleTransactionEvents is a Server-Sent Events handler which streams
+// transaction updates. Clients reconnecting with a Last-Event-ID header first
+// receive the buffered updates they missed. The optional blockchain query
+// parameter limits the stream to a single blockchain
+func HandleTransactionEvents(w http.ResponseWriter, r *http.Request) {
+	blockchain := r.FormValue("blockchain")
+	tenant := tenantID(r)
+	l := log.WithFields(log.Fields{
+		"action":     "HandleTransactionEvents",
+		"blockchain": blockchain,
+	})
+	f, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	sub := etx.Updates.Subscribe()
+	defer etx.Updates.Unsubscribe(sub)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	l.Println("client connected")
+	var last uint64
+	send := func(ev etx.Event) error {
+		if ev.ID <= last {
+			return nil
+		}
+		last = ev.ID
+		if (blockchain != "" && ev.Transaction.Blockchain != blockchain) || ev.Transaction.TenantID != tenant {
+			return nil
+		}
+		jd, err := json.Marshal(ev.Transaction)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", ev.ID, jd); err != nil {
+			return err
+		}
+		f.Flush()
+		return nil
+	}
+	if id, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		for _, ev := range etx.Updates.Since(id) {
+			if err := send(ev); err != nil {
+				l.Printf("error %v", err)
+				return
+			}
+		}
+	}
+	f.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			l.Println("client disconnected")
+			return
+		case ev := <-sub:
+			if err := send(ev); err != nil {
+				l.Printf("error %v", err)
+				return
+			}
+		}
+	}
+}
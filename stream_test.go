@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/robertlestak/txwatch/internal/etx"
+)
+
+func TestTransactionStream(t *testing.T) {
+	srv := httptest.NewServer(setupTestAPI(t))
+	defer srv.Close()
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"all blockchains", "", "poly"},
+		{"blockchain filter", "?blockchain=eth", "eth"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/transactions/stream" + tt.query
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			// the handler subscribes after the upgrade, so updates are published
+			// until one is received
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				for {
+					etx.Updates.Publish(etx.Transaction{ID: testTxID("b"), Blockchain: "poly"})
+					etx.Updates.Publish(etx.Transaction{ID: testTxID("a"), Blockchain: "eth"})
+					select {
+					case <-done:
+						return
+					case <-time.After(time.Millisecond * 20):
+					}
+				}
+			}()
+			conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+			got := etx.Transaction{}
+			if err := conn.ReadJSON(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Blockchain != tt.want {
+				t.Errorf("got a %s update, want %s", got.Blockchain, tt.want)
+			}
+		})
+	}
+}